@@ -13,6 +13,13 @@ func AtomicFloatStore(x *float64, newVal float64) {
 	atomic.StoreUint64(addr, bits)
 }
 
+// atomically stores newVal into x, returning the previous value
+func AtomicFloatSwap(x *float64, newVal float64) float64 {
+	addr := (*uint64)(unsafe.Pointer(x))
+	bits := atomic.SwapUint64(addr, math.Float64bits(newVal))
+	return math.Float64frombits(bits)
+}
+
 func AtomicFloatLoad(x *float64) float64 {
 	addr := (*uint64)(unsafe.Pointer(x))
 	bits := atomic.LoadUint64(addr)
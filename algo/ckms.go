@@ -1,69 +1,228 @@
 package algo
 
 import (
+	"fmt"
 	"math"
+	"slices"
 	"sync"
 )
 
-// CKMS алгоритм для квантилей
-type ckms struct {
-	sync.Mutex
-	epsilon  float64 // точность
-	quantile []float64
-	stream   []pair
+// ckmsBufferSize - столько значений копится перед слиянием в сжатый поток
+const ckmsBufferSize = 512
+
+// Target - квантиль и допустимая ошибка его ранга, например {0.99, 0.001}:
+// оценка 99-го перцентиля лежит между 98.9-м и 99.1-м.
+type Target struct {
+	Quantile float64
+	Epsilon  float64
+}
+
+// CKMS - потоковая оценка целевых квантилей (targeted quantiles) по
+// Cormode, Korn, Muthukrishnan, Srivastava, "Effective Computation of Biased
+// Quantiles over Data Streams". Хранит O(1/ε·log(εn)) сэмплов вместо всего потока,
+// ранг ответа на квантиль q отличается от точного не более чем на ε·n.
+// Безопасна для конкурентных Insert и Query.
+type CKMS struct {
+	mu      sync.Mutex
+	targets []Target
+	buffer  []float64 // ещё не слитые значения
+	samples []ckmsSample
+	n       float64 // количество значений в samples
+	min     float64
+	max     float64
 }
 
-type pair struct {
+// ckmsSample - значение с шириной g (разница рангов с предыдущим сэмплом)
+// и неопределённостью ранга delta
+type ckmsSample struct {
 	value float64
-	g     int
-	delta int
+	g     float64
+	delta float64
 }
 
-func NewCKMS(quantiles []float64) *ckms {
-	return &ckms{
-		epsilon:  defaultEpsolon,
-		quantile: quantiles,
-		stream:   []pair{},
+// NewCKMS создаёт оценку целевых квантилей. Паникует, если у цели внутри (0, 1)
+// ошибка не больше нуля: такая цель запрещает сжатие, и память растёт с потоком.
+func NewCKMS(targets ...Target) *CKMS {
+	for _, t := range targets {
+		if t.Quantile > 0 && t.Quantile < 1 && !(t.Epsilon > 0) {
+			panic(fmt.Sprintf("algo: NewCKMS needs a positive epsilon, got %v for quantile %v", t.Epsilon, t.Quantile))
+		}
+	}
+	return &CKMS{
+		targets: slices.Clone(targets),
+		buffer:  make([]float64, 0, ckmsBufferSize),
 	}
 }
 
-func (c *ckms) Insert(value float64) {
-	c.Lock()
-	defer c.Unlock()
-	c.stream = append(c.stream, pair{value, 1, 0})
-	c.compress()
+// Insert добавляет значение, NaN игнорируется
+func (c *CKMS) Insert(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buffer = append(c.buffer, value)
+	if len(c.buffer) == cap(c.buffer) {
+		c.flush()
+	}
 }
 
-func (c *ckms) Query(q float64) float64 {
-	c.Lock()
-	defer c.Unlock()
-
-	if len(c.stream) == 0 {
+// Query оценивает квантиль q, NaN если значений нет.
+// Квантили 0 и 1 точны: это минимум и максимум потока.
+func (c *CKMS) Query(q float64) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+	if c.n == 0 {
 		return math.NaN()
 	}
+	switch {
+	case q <= 0:
+		return c.min
+	case q >= 1:
+		return c.max
+	}
+	rank := math.Ceil(q * c.n)
+	rank += c.invariant(rank) / 2
+	prev := c.samples[0]
+	var r float64
+	for _, cur := range c.samples[1:] {
+		r += prev.g
+		if r+cur.g+cur.delta > rank {
+			return prev.value
+		}
+		prev = cur
+	}
+	return prev.value
+}
+
+// Count возвращает количество вставленных значений
+func (c *CKMS) Count() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint64(c.n) + uint64(len(c.buffer))
+}
 
-	rank := int(q * float64(len(c.stream)))
-	if rank >= len(c.stream) {
-		rank = len(c.stream) - 1
+// Merge вливает значения другого CKMS. Ошибки рангов складываются,
+// поэтому после слияния гарантия ослабевает до суммы ошибок обоих.
+func (c *CKMS) Merge(other QuantileSketch) error {
+	o, ok := other.(*CKMS)
+	if !ok {
+		return fmt.Errorf("%w: %T into %T", ErrIncompatibleSketch, other, c)
 	}
-	return c.stream[rank].value
+	o.mu.Lock()
+	o.flush()
+	samples := slices.Clone(o.samples)
+	lo, hi := o.min, o.max
+	o.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+	c.mergeSamples(samples, lo, hi)
+	return nil
+}
+
+// Reset забывает все значения
+func (c *CKMS) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buffer = c.buffer[:0]
+	c.samples = nil
+	c.n = 0
+}
+
+// invariant - допустимая неопределённость сэмпла ранга r:
+// минимум по целям, чтобы каждая цель уложилась в свою ошибку.
+func (c *CKMS) invariant(r float64) float64 {
+	return c.invariantSpan(r, r)
 }
 
-func (c *ckms) compress() {
-	// Упрощённый вариант компрессии
-	if len(c.stream) < 2 {
+// invariantSpan - минимум invariant на отрезке рангов [lo, hi].
+// Для каждой цели f(r) убывает до ранга q·n, где равна 2εn, и растёт после,
+// поэтому сэмпл, укладывающийся в минимум по всему отрезку, который он покрывает,
+// даёт ошибку не больше ε·n на любом запросе внутри отрезка.
+func (c *CKMS) invariantSpan(lo, hi float64) float64 {
+	res := math.MaxFloat64
+	for _, t := range c.targets {
+		if t.Quantile <= 0 || t.Quantile >= 1 {
+			continue // минимум и максимум хранятся точно
+		}
+		r := min(max(t.Quantile*c.n, lo), hi)
+		var f float64
+		if r >= t.Quantile*c.n {
+			f = 2 * t.Epsilon * r / t.Quantile
+		} else {
+			f = 2 * t.Epsilon * (c.n - r) / (1 - t.Quantile)
+		}
+		res = min(res, f)
+	}
+	return res
+}
+
+// flush сливает отсортированный буфер с потоком и сжимает его
+func (c *CKMS) flush() {
+	if len(c.buffer) == 0 {
 		return
 	}
+	slices.Sort(c.buffer)
+	incoming := make([]ckmsSample, len(c.buffer))
+	for i, value := range c.buffer {
+		incoming[i] = ckmsSample{value: value, g: 1}
+	}
+	c.buffer = c.buffer[:0]
+	c.mergeSamples(incoming, incoming[0].value, incoming[len(incoming)-1].value)
+}
 
-	newStream := []pair{c.stream[0]}
-	for i := 1; i < len(c.stream); i++ {
-		last := &newStream[len(newStream)-1]
-		curr := c.stream[i]
-		if curr.value == last.value {
-			last.g += curr.g
+// mergeSamples вливает отсортированные сэмплы с минимумом lo и максимумом hi в поток
+func (c *CKMS) mergeSamples(incoming []ckmsSample, lo, hi float64) {
+	if len(incoming) == 0 {
+		return
+	}
+	if c.n == 0 {
+		c.min, c.max = lo, hi
+	} else {
+		c.min, c.max = min(c.min, lo), max(c.max, hi)
+	}
+	merged := make([]ckmsSample, 0, len(c.samples)+len(incoming))
+	i := 0
+	for _, sample := range incoming {
+		for i < len(c.samples) && c.samples[i].value <= sample.value {
+			merged = append(merged, c.samples[i])
+			i++
+		}
+		if i > 0 && i < len(c.samples) {
+			// новое значение между сэмплами: его ранг известен с точностью следующего
+			sample.delta += c.samples[i].g + c.samples[i].delta - 1
+		}
+		merged = append(merged, sample)
+		c.n += sample.g
+	}
+	c.samples = append(merged, c.samples[i:]...)
+	c.compress()
+}
+
+// compress объединяет соседние сэмплы, пока это не нарушает invariant.
+// Первый и последний сэмплы не поглощаются, их ранги точны.
+func (c *CKMS) compress() {
+	if len(c.samples) < 3 {
+		return
+	}
+	last := len(c.samples) - 1
+	res := make([]ckmsSample, 0, len(c.samples))
+	res = append(res, c.samples[last])
+	x := c.samples[last-1]
+	r := c.n - c.samples[last].g - x.g // ранг перед x
+	for i := last - 2; i >= 1; i-- {
+		cur := c.samples[i]
+		r -= cur.g // ранг перед cur
+		if width := cur.g + x.g + x.delta; width <= c.invariantSpan(r, r+width) {
+			x.g += cur.g
 		} else {
-			newStream = append(newStream, curr)
+			res = append(res, x)
+			x = cur
 		}
 	}
-	c.stream = newStream
+	res = append(res, x, c.samples[0])
+	slices.Reverse(res)
+	c.samples = res
 }
@@ -0,0 +1,148 @@
+package algo
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ckmsTargets = []Target{
+	{Quantile: 0.5, Epsilon: 0.05},
+	{Quantile: 0.9, Epsilon: 0.01},
+	{Quantile: 0.99, Epsilon: 0.001},
+}
+
+// assertRankError проверяет, что ранг оценки лежит в пределах ±ε·n от целевого
+func assertRankError(t *testing.T, sorted []float64, target Target, estimate float64) {
+	t.Helper()
+	n := float64(len(sorted))
+	lo := float64(sort.SearchFloat64s(sorted, estimate))
+	hi := float64(sort.Search(len(sorted), func(i int) bool { return sorted[i] > estimate }))
+	want := target.Quantile * n
+	slack := target.Epsilon * n
+	assert.True(t, hi >= want-slack && lo <= want+slack,
+		"квантиль %v: ранги оценки [%v, %v] вне %v±%v", target.Quantile, lo, hi, want, slack)
+}
+
+// Тест точности на больших случайных потоках
+//
+//	🔍 Суть: Вставляем 200000 значений из разных распределений и сверяем ранги оценок с точными.
+//	✅ Если да: Ошибка ранга каждой цели не превышает её ε·n.
+//	❌ Если нет: Оценка вышла за гарантию CKMS, значит сломано слияние или сжатие.
+func TestCKMS_Accuracy(t *testing.T) {
+	const n = 200000
+	rng := rand.New(rand.NewPCG(1, 2))
+	streams := map[string]func() float64{
+		"uniform":     rng.Float64,
+		"normal":      rng.NormFloat64,
+		"exponential": rng.ExpFloat64,
+		"ascending":   func() float64 { return 0 }, // заполняется ниже
+	}
+	for name, next := range streams {
+		t.Run(name, func(t *testing.T) {
+			c := NewCKMS(ckmsTargets...)
+			values := make([]float64, n)
+			for i := range values {
+				values[i] = next()
+				if name == "ascending" {
+					values[i] = float64(i)
+				}
+				c.Insert(values[i])
+			}
+			slices.Sort(values)
+			for _, target := range ckmsTargets {
+				assertRankError(t, values, target, c.Query(target.Quantile))
+			}
+			assert.Equal(t, values[0], c.Query(0), "минимум точен")
+			assert.Equal(t, values[n-1], c.Query(1), "максимум точен")
+			assert.Equal(t, uint64(n), c.Count())
+		})
+	}
+}
+
+// Тест ограниченной памяти
+//
+//	🔍 Суть: После миллиона значений поток сжат до малой доли входа.
+//	✅ Если да: Сэмплов на порядки меньше, чем значений.
+//	❌ Если нет: compress не объединяет сэмплы и память растёт линейно.
+func TestCKMS_BoundedMemory(t *testing.T) {
+	c := NewCKMS(ckmsTargets...)
+	rng := rand.New(rand.NewPCG(3, 4))
+	for i := 0; i < 1000000; i++ {
+		c.Insert(rng.Float64())
+	}
+	c.Query(0.5)
+	assert.Less(t, len(c.samples), 5000)
+}
+
+// Тест на конкурентные вставки и запросы
+//
+//	🛠 Суть: Пишем из нескольких горутин и одновременно читаем квантили, под -race.
+//	✅ Если гонки нет, все значения учтены и медиана в пределах ошибки
+//	❌ Если есть гонка, тест зафейлится или race detector сообщит о ней
+func TestCKMS_Concurrent(t *testing.T) {
+	c := NewCKMS(ckmsTargets...)
+	const numWriters, numIter = 8, 10000
+	var wg sync.WaitGroup
+	wg.Add(numWriters)
+	for w := 0; w < numWriters; w++ {
+		go func(base int) {
+			defer wg.Done()
+			for i := 0; i < numIter; i++ {
+				c.Insert(float64(base*numIter + i))
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for querying := true; querying; {
+		select {
+		case <-done:
+			querying = false
+		default:
+			_ = c.Query(0.99)
+		}
+	}
+	require.Equal(t, uint64(numWriters*numIter), c.Count())
+	sorted := make([]float64, numWriters*numIter)
+	for i := range sorted {
+		sorted[i] = float64(i)
+	}
+	assertRankError(t, sorted, ckmsTargets[0], c.Query(0.5))
+}
+
+// Тест на запрос из пустого CKMS
+//
+//	🔍 Суть: Без значений, а также после Reset, Query() возвращает NaN.
+//	✅ Если да: Вернётся NaN, данных для оценки нет.
+//	❌ Если нет: Возвращается число, оставшееся от прошлых значений.
+func TestCKMS_EmptyQuery(t *testing.T) {
+	c := NewCKMS(ckmsTargets...)
+	assert.True(t, math.IsNaN(c.Query(0.5)))
+	c.Insert(1)
+	assert.Equal(t, 1.0, c.Query(0.5))
+	c.Reset()
+	assert.True(t, math.IsNaN(c.Query(0.5)))
+	assert.Zero(t, c.Count())
+}
+
+// Тест на недопустимую ошибку цели
+//
+//	🔍 Суть: Цель с ε ≤ 0 или NaN внутри (0, 1) запрещает сжатие, CKMS хранил бы весь поток.
+//	✅ Если да: NewCKMS паникует, а у квантилей 0 и 1 ошибка не важна, они точны.
+//	❌ Если нет: Память растёт без ограничений.
+func TestCKMS_InvalidEpsilon(t *testing.T) {
+	assert.Panics(t, func() { NewCKMS(Target{Quantile: 0.5, Epsilon: 0}) })
+	assert.Panics(t, func() { NewCKMS(Target{Quantile: 0.5, Epsilon: -0.01}) })
+	assert.Panics(t, func() { NewCKMS(Target{Quantile: 0.9, Epsilon: math.NaN()}) })
+	assert.NotPanics(t, func() { NewCKMS(Target{Quantile: 1, Epsilon: 0}, Target{Quantile: 0, Epsilon: 0}) })
+}
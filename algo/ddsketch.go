@@ -0,0 +1,189 @@
+package algo
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ddMinIndexable - значения по модулю меньше считаются нулём
+const ddMinIndexable = 0x1p-1022
+
+// DDSketch - оценка квантилей с относительной ошибкой значения (Masson, Rim, Lee,
+// "DDSketch: A Fast and Fully-Mergeable Quantile Sketch with Relative-Error Guarantees").
+// Значения раскладываются по логарифмическим корзинам с ростом γ = (1+α)/(1-α),
+// поэтому ответ отличается от точного квантиля не более чем в (1±α) раз.
+// При maxBins корзин самые малые по модулю схлопываются, и гарантия
+// сохраняется для остальных.
+type DDSketch struct {
+	mu       sync.Mutex
+	alpha    float64
+	logGamma float64
+	maxBins  int
+	positive ddStore
+	negative ddStore // по модулю
+	zero     uint64
+	count    uint64
+	min      float64
+	max      float64
+}
+
+// ddStore - плотный массив счётчиков корзин, начиная с ключа offset
+type ddStore struct {
+	bins   []uint64
+	offset int
+}
+
+// NewDDSketch создаёт скетч с относительной точностью alpha, например 0.01.
+// maxBins ограничивает память корзин каждого знака, 0 - без ограничения.
+// Паникует, если alpha не из (0, 1).
+func NewDDSketch(alpha float64, maxBins int) *DDSketch {
+	if !(alpha > 0 && alpha < 1) {
+		panic("algo: NewDDSketch needs alpha in (0, 1)")
+	}
+	return &DDSketch{
+		alpha:    alpha,
+		logGamma: math.Log((1 + alpha) / (1 - alpha)),
+		maxBins:  maxBins,
+	}
+}
+
+func (d *DDSketch) Insert(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observeRange(value, value)
+	switch {
+	case value >= ddMinIndexable:
+		d.positive.add(d.key(value), 1, d.maxBins)
+	case value <= -ddMinIndexable:
+		d.negative.add(d.key(-value), 1, d.maxBins)
+	default:
+		d.zero++
+	}
+	d.count++
+}
+
+func (d *DDSketch) Query(q float64) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.count == 0:
+		return math.NaN()
+	case q <= 0:
+		return d.min
+	case q >= 1:
+		return d.max
+	}
+	rank := q * float64(d.count-1)
+	var n float64
+	for i := len(d.negative.bins) - 1; i >= 0; i-- {
+		if n += float64(d.negative.bins[i]); n > rank {
+			return d.clamp(-d.value(d.negative.offset + i))
+		}
+	}
+	if n += float64(d.zero); n > rank {
+		return 0
+	}
+	for i, c := range d.positive.bins {
+		if n += float64(c); n > rank {
+			return d.clamp(d.value(d.positive.offset + i))
+		}
+	}
+	return d.max
+}
+
+func (d *DDSketch) Merge(other QuantileSketch) error {
+	o, ok := other.(*DDSketch)
+	if !ok || o.alpha != d.alpha {
+		return fmt.Errorf("%w: %T into %T with alpha %v", ErrIncompatibleSketch, other, d, d.alpha)
+	}
+	o.mu.Lock()
+	positive, negative := o.positive.clone(), o.negative.clone()
+	zero, count, lo, hi := o.zero, o.count, o.min, o.max
+	o.mu.Unlock()
+	if count == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observeRange(lo, hi)
+	for i, c := range positive.bins {
+		if c > 0 {
+			d.positive.add(positive.offset+i, c, d.maxBins)
+		}
+	}
+	for i, c := range negative.bins {
+		if c > 0 {
+			d.negative.add(negative.offset+i, c, d.maxBins)
+		}
+	}
+	d.zero += zero
+	d.count += count
+	return nil
+}
+
+func (d *DDSketch) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.positive, d.negative = ddStore{}, ddStore{}
+	d.zero, d.count = 0, 0
+}
+
+func (d *DDSketch) Count() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.count
+}
+
+// key - номер корзины (γ^(key-1), γ^key], содержащей value > 0
+func (d *DDSketch) key(value float64) int {
+	return int(math.Ceil(math.Log(value) / d.logGamma))
+}
+
+// value - точка корзины key с относительной ошибкой не больше α для всей корзины
+func (d *DDSketch) value(key int) float64 {
+	return 2 * math.Exp(float64(key)*d.logGamma) / (1 + math.Exp(d.logGamma))
+}
+
+func (d *DDSketch) observeRange(lo, hi float64) {
+	if d.count == 0 {
+		d.min, d.max = lo, hi
+		return
+	}
+	d.min, d.max = min(d.min, lo), max(d.max, hi)
+}
+
+func (d *DDSketch) clamp(value float64) float64 {
+	return min(max(value, d.min), d.max)
+}
+
+// add прибавляет n к корзине key. Корзины ниже последних maxBins
+// схлопываются в самую нижнюю из оставшихся.
+func (s *ddStore) add(key int, n uint64, maxBins int) {
+	if len(s.bins) == 0 {
+		s.bins, s.offset = make([]uint64, 1), key
+	}
+	top := s.offset + len(s.bins) - 1
+	lo, hi := min(key, s.offset), max(key, top)
+	if maxBins > 0 && hi-lo+1 > maxBins {
+		lo = hi - maxBins + 1
+	}
+	if lo != s.offset || hi != top {
+		bins := make([]uint64, hi-lo+1)
+		for i, c := range s.bins {
+			bins[max(s.offset+i, lo)-lo] += c
+		}
+		s.bins, s.offset = bins, lo
+	}
+	s.bins[max(key, lo)-lo] += n
+}
+
+func (s *ddStore) clone() ddStore {
+	return ddStore{
+		bins:   append([]uint64(nil), s.bins...),
+		offset: s.offset,
+	}
+}
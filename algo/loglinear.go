@@ -0,0 +1,141 @@
+package algo
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// LogLinear - HDR-гистограмма: диапазон [lowest, highest] делится на степени двойки,
+// каждая - на 2^precision равных корзин. Память фиксирована, относительная
+// ошибка значения не больше 2^-(precision+1). Значения вне диапазона
+// прижимаются к его краям.
+type LogLinear struct {
+	mu         sync.Mutex
+	lowest     float64
+	highest    float64
+	precision  int
+	minExp     int
+	subBuckets int
+	counts     []uint64
+	count      uint64
+	min        float64
+	max        float64
+}
+
+// NewLogLinear создаёт гистограмму диапазона [lowest, highest] с 2^precision
+// корзинами на степень двойки. Паникует, если lowest не положителен,
+// highest не больше lowest или precision не из [1, 16].
+func NewLogLinear(lowest, highest float64, precision int) *LogLinear {
+	switch {
+	case !(lowest > 0):
+		panic("algo: NewLogLinear needs a positive lowest")
+	case !(highest > lowest) || math.IsInf(highest, 1):
+		panic("algo: NewLogLinear needs a finite highest above lowest")
+	case precision < 1 || precision > 16:
+		panic("algo: NewLogLinear needs a precision in [1, 16]")
+	}
+	_, minExp := math.Frexp(lowest)
+	_, maxExp := math.Frexp(highest)
+	subBuckets := 1 << precision
+	return &LogLinear{
+		lowest:     lowest,
+		highest:    highest,
+		precision:  precision,
+		minExp:     minExp,
+		subBuckets: subBuckets,
+		counts:     make([]uint64, (maxExp-minExp+1)*subBuckets),
+	}
+}
+
+func (l *LogLinear) Insert(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		l.min, l.max = value, value
+	} else {
+		l.min, l.max = min(l.min, value), max(l.max, value)
+	}
+	l.counts[l.index(value)]++
+	l.count++
+}
+
+func (l *LogLinear) Query(q float64) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.count == 0:
+		return math.NaN()
+	case q <= 0:
+		return l.min
+	case q >= 1:
+		return l.max
+	}
+	rank := q * float64(l.count-1)
+	var n float64
+	for i, c := range l.counts {
+		if n += float64(c); n > rank {
+			return min(max(l.value(i), l.min), l.max)
+		}
+	}
+	return l.max
+}
+
+func (l *LogLinear) Merge(other QuantileSketch) error {
+	o, ok := other.(*LogLinear)
+	if !ok || o.lowest != l.lowest || o.highest != l.highest || o.precision != l.precision {
+		return fmt.Errorf("%w: %T into %T [%v, %v] precision %d",
+			ErrIncompatibleSketch, other, l, l.lowest, l.highest, l.precision)
+	}
+	o.mu.Lock()
+	counts := append([]uint64(nil), o.counts...)
+	count, lo, hi := o.count, o.min, o.max
+	o.mu.Unlock()
+	if count == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		l.min, l.max = lo, hi
+	} else {
+		l.min, l.max = min(l.min, lo), max(l.max, hi)
+	}
+	for i, c := range counts {
+		l.counts[i] += c
+	}
+	l.count += count
+	return nil
+}
+
+func (l *LogLinear) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.counts)
+	l.count = 0
+}
+
+func (l *LogLinear) Count() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// index - номер корзины значения, прижатого к диапазону
+func (l *LogLinear) index(value float64) int {
+	frac, exp := math.Frexp(min(max(value, l.lowest), l.highest))
+	sub := int((2*frac - 1) * float64(l.subBuckets)) // frac из [0.5, 1)
+	return (exp-l.minExp)*l.subBuckets + sub
+}
+
+// value - середина корзины i
+func (l *LogLinear) value(i int) float64 {
+	exp := i/l.subBuckets + l.minExp
+	sub := float64(i % l.subBuckets)
+	lower := math.Ldexp(0.5*(1+sub/float64(l.subBuckets)), exp)
+	upper := math.Ldexp(0.5*(1+(sub+1)/float64(l.subBuckets)), exp)
+	return (lower + upper) / 2
+}
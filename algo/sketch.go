@@ -0,0 +1,28 @@
+package algo
+
+import "errors"
+
+// ErrIncompatibleSketch - Merge получил скетч другого типа или с другими параметрами
+var ErrIncompatibleSketch = errors.New("incompatible quantile sketch")
+
+// QuantileSketch - потоковая оценка квантилей с ограниченной памятью.
+// Реализации безопасны для конкурентного использования.
+type QuantileSketch interface {
+	// Insert добавляет значение
+	Insert(value float64)
+	// Query оценивает квантиль q из [0, 1], NaN если значений нет
+	Query(q float64) float64
+	// Merge вливает значения другого скетча того же типа и параметров
+	Merge(other QuantileSketch) error
+	// Reset забывает все значения
+	Reset()
+	// Count возвращает количество значений
+	Count() uint64
+}
+
+var (
+	_ QuantileSketch = (*CKMS)(nil)
+	_ QuantileSketch = (*DDSketch)(nil)
+	_ QuantileSketch = (*TDigest)(nil)
+	_ QuantileSketch = (*LogLinear)(nil)
+)
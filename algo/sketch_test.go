@@ -0,0 +1,146 @@
+package algo
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sketchFactories = map[string]func() QuantileSketch{
+	"ckms":      func() QuantileSketch { return NewCKMS(ckmsTargets...) },
+	"ddsketch":  func() QuantileSketch { return NewDDSketch(0.01, 2048) },
+	"tdigest":   func() QuantileSketch { return NewTDigest(100) },
+	"loglinear": func() QuantileSketch { return NewLogLinear(1e-6, 1e6, 7) },
+}
+
+// exactQuantile - точный квантиль отсортированных значений
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// Тест относительной точности DDSketch и LogLinear
+//
+//	🔍 Суть: На логнормальном потоке ответы сравниваются с точными квантилями.
+//	✅ Если да: Относительная ошибка не больше α (DDSketch) и 2^-(precision+1) (LogLinear).
+//	❌ Если нет: Корзины считаются неверно, гарантия относительной ошибки нарушена.
+func TestSketch_RelativeAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	values := make([]float64, 100000)
+	dd, hdr := NewDDSketch(0.01, 0), NewLogLinear(1e-6, 1e6, 7)
+	for i := range values {
+		values[i] = math.Exp(rng.NormFloat64() * 2)
+		dd.Insert(values[i])
+		hdr.Insert(values[i])
+	}
+	slices.Sort(values)
+	for _, q := range []float64{0.01, 0.25, 0.5, 0.9, 0.99, 0.999} {
+		exact := exactQuantile(values, q)
+		assert.InEpsilon(t, exact, dd.Query(q), 0.01+1e-9, "DDSketch квантиль %v", q)
+		assert.InEpsilon(t, exact, hdr.Query(q), 1.0/256+1e-9, "LogLinear квантиль %v", q)
+	}
+}
+
+// Тест точности хвостов t-digest
+//
+//	🔍 Суть: На равномерном потоке крайние квантили t-digest почти точны.
+//	✅ Если да: Ошибка ранга 0.999 и 0.001 меньше 0.0005, медианы - меньше 0.01.
+//	❌ Если нет: Функция масштаба не держит хвостовые центроиды мелкими.
+func TestSketch_TDigestTails(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	td := NewTDigest(100)
+	for i := 0; i < 100000; i++ {
+		td.Insert(rng.Float64())
+	}
+	assert.InDelta(t, 0.999, td.Query(0.999), 0.0005)
+	assert.InDelta(t, 0.001, td.Query(0.001), 0.0005)
+	assert.InDelta(t, 0.5, td.Query(0.5), 0.01)
+	assert.LessOrEqual(t, len(td.centroids), 200, "память O(δ)")
+}
+
+// Тест слияния скетчей
+//
+//	🔍 Суть: Две половины потока, слитые Merge, отвечают как скетч всего потока.
+//	✅ Если да: Количество сложено, медиана близка к точной.
+//	❌ Если нет: Merge теряет или дублирует значения.
+func TestSketch_Merge(t *testing.T) {
+	for name, factory := range sketchFactories {
+		t.Run(name, func(t *testing.T) {
+			a, b := factory(), factory()
+			for i := 1; i <= 10000; i++ {
+				if i%2 == 0 {
+					a.Insert(float64(i))
+				} else {
+					b.Insert(float64(i))
+				}
+			}
+			require.NoError(t, a.Merge(b))
+			assert.Equal(t, uint64(10000), a.Count())
+			assert.InEpsilon(t, 5000, a.Query(0.5), 0.05)
+			assert.Equal(t, 1.0, a.Query(0))
+			assert.Equal(t, 10000.0, a.Query(1))
+		})
+	}
+	err := NewDDSketch(0.01, 0).Merge(NewDDSketch(0.02, 0))
+	assert.True(t, errors.Is(err, ErrIncompatibleSketch))
+	err = NewTDigest(100).Merge(NewCKMS())
+	assert.True(t, errors.Is(err, ErrIncompatibleSketch))
+}
+
+// Тест пустых скетчей и Reset
+//
+//	🔍 Суть: Без значений и после Reset все скетчи отвечают NaN.
+//	✅ Если да: Query возвращает NaN, Count равен нулю.
+//	❌ Если нет: Остаются значения от прошлого наполнения.
+func TestSketch_EmptyAndReset(t *testing.T) {
+	for name, factory := range sketchFactories {
+		t.Run(name, func(t *testing.T) {
+			s := factory()
+			assert.True(t, math.IsNaN(s.Query(0.5)))
+			s.Insert(1)
+			s.Insert(math.NaN())
+			assert.Equal(t, uint64(1), s.Count(), "NaN игнорируется")
+			s.Reset()
+			assert.True(t, math.IsNaN(s.Query(0.5)))
+			assert.Zero(t, s.Count())
+		})
+	}
+}
+
+// Тест на конкурентные вставки, запросы и слияния
+//
+//	🛠 Суть: Пишем из нескольких горутин, одновременно читаем и вливаем в другой скетч, под -race.
+//	✅ Если гонки нет, все значения учтены
+//	❌ Если есть гонка, race detector сообщит о ней
+func TestSketch_Concurrent(t *testing.T) {
+	for name, factory := range sketchFactories {
+		t.Run(name, func(t *testing.T) {
+			s, sink := factory(), factory()
+			const numWriters, numIter = 4, 2000
+			var wg sync.WaitGroup
+			wg.Add(numWriters + 1)
+			for w := 0; w < numWriters; w++ {
+				go func() {
+					defer wg.Done()
+					for i := 1; i <= numIter; i++ {
+						s.Insert(float64(i))
+					}
+				}()
+			}
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					_ = s.Query(0.9)
+					_ = sink.Merge(s)
+				}
+			}()
+			wg.Wait()
+			assert.Equal(t, uint64(numWriters*numIter), s.Count())
+		})
+	}
+}
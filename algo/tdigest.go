@@ -0,0 +1,179 @@
+package algo
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+)
+
+// TDigest - оценка квантилей слиянием центроидов (Dunning, Ertl,
+// "Computing Extremely Accurate Quantiles Using t-Digests").
+// Функция масштаба k1 = δ/2π·asin(2q-1) держит центроиды у хвостов мелкими,
+// поэтому крайние квантили вроде 0.999 точнее средних. Память - O(δ) центроидов.
+type TDigest struct {
+	mu          sync.Mutex
+	compression float64
+	centroids   []centroid // по возрастанию mean
+	buffer      []centroid // ещё не слитые
+	weight      float64    // вес centroids
+	min         float64
+	max         float64
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// NewTDigest создаёт дайджест со сжатием compression, например 100:
+// около compression центроидов, больше - точнее. Паникует, если compression < 1.
+func NewTDigest(compression float64) *TDigest {
+	if !(compression >= 1) {
+		panic("algo: NewTDigest needs a compression of at least 1")
+	}
+	return &TDigest{
+		compression: compression,
+		buffer:      make([]centroid, 0, int(5*compression)),
+	}
+}
+
+func (t *TDigest) Insert(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(centroid{mean: value, weight: 1})
+}
+
+func (t *TDigest) Query(q float64) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flush()
+	switch {
+	case t.weight == 0:
+		return math.NaN()
+	case q <= 0:
+		return t.min
+	case q >= 1:
+		return t.max
+	}
+	// центроид считается сосредоточенным в середине своего веса,
+	// между серединами соседних центроидов - линейная интерполяция
+	target := q * t.weight
+	var cum, prevMid float64
+	prevMean := t.min
+	for _, c := range t.centroids {
+		mid := cum + c.weight/2
+		if target < mid {
+			if c.weight == 1 && target >= cum {
+				return c.mean // одиночное значение известно точно
+			}
+			return prevMean + (c.mean-prevMean)*(target-prevMid)/(mid-prevMid)
+		}
+		cum += c.weight
+		prevMid, prevMean = mid, c.mean
+	}
+	if t.weight == prevMid {
+		return t.max
+	}
+	return prevMean + (t.max-prevMean)*(target-prevMid)/(t.weight-prevMid)
+}
+
+func (t *TDigest) Merge(other QuantileSketch) error {
+	o, ok := other.(*TDigest)
+	if !ok {
+		return fmt.Errorf("%w: %T into %T", ErrIncompatibleSketch, other, t)
+	}
+	o.mu.Lock()
+	o.flush()
+	centroids := slices.Clone(o.centroids)
+	lo, hi := o.min, o.max
+	o.mu.Unlock()
+	if len(centroids) == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buffer = append(t.buffer, centroids...)
+	t.flush()
+	// центроиды усредняют крайние значения, границы берутся точными
+	t.min, t.max = min(t.min, lo), max(t.max, hi)
+	return nil
+}
+
+func (t *TDigest) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.centroids = nil
+	t.buffer = t.buffer[:0]
+	t.weight = 0
+}
+
+func (t *TDigest) Count() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := t.weight
+	for _, c := range t.buffer {
+		res += c.weight
+	}
+	return uint64(res)
+}
+
+func (t *TDigest) add(c centroid) {
+	t.buffer = append(t.buffer, c)
+	if len(t.buffer) == cap(t.buffer) {
+		t.flush()
+	}
+}
+
+// flush сливает буфер с центроидами: соседи объединяются,
+// пока центроид покрывает не больше единицы шкалы k1.
+func (t *TDigest) flush() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buffer...)
+	slices.SortFunc(all, func(a, b centroid) int {
+		switch {
+		case a.mean < b.mean:
+			return -1
+		case a.mean > b.mean:
+			return 1
+		}
+		return 0
+	})
+	if t.weight == 0 {
+		t.min, t.max = all[0].mean, all[len(all)-1].mean
+	} else {
+		t.min, t.max = min(t.min, all[0].mean), max(t.max, all[len(all)-1].mean)
+	}
+	var total float64
+	for _, c := range all {
+		total += c.weight
+	}
+	res := make([]centroid, 0, int(2*t.compression))
+	cur := all[0]
+	var done float64
+	kLow := t.k(0)
+	for _, next := range all[1:] {
+		if t.k((done+cur.weight+next.weight)/total)-kLow <= 1 {
+			cur.weight += next.weight
+			cur.mean += (next.mean - cur.mean) * next.weight / cur.weight
+			continue
+		}
+		done += cur.weight
+		kLow = t.k(done / total)
+		res = append(res, cur)
+		cur = next
+	}
+	t.centroids = append(res, cur)
+	t.buffer = t.buffer[:0]
+	t.weight = total
+}
+
+// k - функция масштаба k1
+func (t *TDigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*min(q, 1)-1)
+}
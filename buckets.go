@@ -0,0 +1,77 @@
+package zpm
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// DefaultBuckets are the bounds of histograms created without Buckets,
+// tailored to request durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// LinearBuckets returns count bounds, the first at start, each next one width above.
+// It panics when count is not positive.
+func LinearBuckets(start, width float64, count int) []float64 {
+	if count < 1 {
+		panic("zpm: LinearBuckets needs a positive count")
+	}
+	res := make([]float64, count)
+	for i := range res {
+		res[i] = start + float64(i)*width
+	}
+	return res
+}
+
+// ExponentialBuckets returns count bounds, the first at start, each next one factor times larger.
+// It panics when count is not positive, start is not positive or factor is not above 1.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	switch {
+	case count < 1:
+		panic("zpm: ExponentialBuckets needs a positive count")
+	case !(start > 0):
+		panic("zpm: ExponentialBuckets needs a positive start")
+	case !(factor > 1):
+		panic("zpm: ExponentialBuckets needs a factor above 1")
+	}
+	res := make([]float64, count)
+	res[0] = start
+	for i := 1; i < count; i++ {
+		res[i] = res[i-1] * factor
+	}
+	return res
+}
+
+// ExponentialBucketsRange returns count exponential bounds from minBound to maxBound.
+// It panics when count is below 2, minBound is not positive or maxBound is not above it.
+func ExponentialBucketsRange(minBound, maxBound float64, count int) []float64 {
+	switch {
+	case count < 2:
+		panic("zpm: ExponentialBucketsRange needs a count of at least 2")
+	case !(minBound > 0):
+		panic("zpm: ExponentialBucketsRange needs a positive min")
+	case !(maxBound > minBound):
+		panic("zpm: ExponentialBucketsRange needs a max above min")
+	}
+	factor := math.Pow(maxBound/minBound, 1/float64(count-1))
+	res := ExponentialBuckets(minBound, factor, count)
+	res[count-1] = maxBound // no rounding drift on the last bound
+	return res
+}
+
+// makeBuckets returns sorted, deduplicated bounds. +Inf is dropped, as it is
+// always encoded anyway. NaN bounds are dropped too and reported as an error.
+func makeBuckets(bounds []float64) ([]float64, error) {
+	res := make([]float64, 0, len(bounds))
+	var err error
+	for _, bound := range bounds {
+		switch {
+		case math.IsNaN(bound):
+			err = fmt.Errorf("%w: NaN bound in %v", ErrInvalidBuckets, bounds)
+		case !math.IsInf(bound, 1):
+			res = append(res, bound)
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res), err
+}
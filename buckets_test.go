@@ -0,0 +1,58 @@
+package zpm_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestBucketGenerators(t *testing.T) {
+	assert.Equal(t, []float64{1, 3, 5}, zpm.LinearBuckets(1, 2, 3))
+	assert.Equal(t, []float64{1, 2, 4, 8}, zpm.ExponentialBuckets(1, 2, 4))
+	res := zpm.ExponentialBucketsRange(1, 1000, 4)
+	require.Len(t, res, 4)
+	assert.Equal(t, 1.0, res[0])
+	assert.InDelta(t, 10, res[1], 1e-9)
+	assert.InDelta(t, 100, res[2], 1e-9)
+	assert.Equal(t, 1000.0, res[3])
+	assert.Panics(t, func() { zpm.LinearBuckets(0, 1, 0) })
+	assert.Panics(t, func() { zpm.ExponentialBuckets(1, 1, 3) })
+	assert.Panics(t, func() { zpm.ExponentialBucketsRange(10, 1, 3) })
+}
+
+func TestBucketValidation(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().OptErrorHandler(func(err error) {
+		reported = append(reported, err)
+	})
+	hist := srv.Histogram("validated").Buckets(5, 1, math.Inf(1), 1, 2).Observe(3)
+	assert.Empty(t, reported)
+	snapshot := hist.State().Snapshot().Histogram
+	var bounds []float64
+	for _, bucket := range snapshot.GetBucket() {
+		bounds = append(bounds, bucket.GetUpperBound())
+	}
+	assert.Equal(t, []float64{1, 2, 5}, bounds)
+	assert.Equal(t, uint64(1), snapshot.GetBucket()[2].GetCumulativeCount())
+
+	for i := 0; i < 3; i++ {
+		srv.Histogram("nan").Buckets(1, math.NaN()).Observe(1)
+	}
+	require.Len(t, reported, 1, "an invalid call site is reported once")
+	assert.True(t, errors.Is(reported[0], zpm.ErrInvalidBuckets))
+}
+
+func TestHistogramDefaultBuckets(t *testing.T) {
+	srv := zpm.NewServer()
+	snapshot := srv.Histogram("defaults").Observe(0.3).State().Snapshot().Histogram
+	require.Len(t, snapshot.GetBucket(), len(zpm.DefaultBuckets))
+	assert.Equal(t, zpm.DefaultBuckets[0], snapshot.GetBucket()[0].GetUpperBound())
+
+	native := srv.Histogram("native_only").Native(0).Observe(0.3).State().Snapshot().Histogram
+	assert.Empty(t, native.GetBucket())
+}
@@ -0,0 +1,146 @@
+package zpm
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xakepp35/zpm/algo"
+)
+
+// DefaultCallbackTimeout bounds a CounterFunc or GaugeFunc callback during an export
+const DefaultCallbackTimeout = time.Second
+
+// callback computes the value of a CounterFunc or GaugeFunc series at export
+type callback struct {
+	fn      func() float64
+	running chan struct{} // holds a token while a call is in flight
+}
+
+func newCallback(fn func() float64) *callback {
+	return &callback{
+		fn:      fn,
+		running: make(chan struct{}, 1),
+	}
+}
+
+type callbackResult struct {
+	value float64
+	err   error
+}
+
+// eval calls the callback, recovering its panic and giving up after timeout,
+// zero meaning no timeout, or once ctx is done. A call given up on keeps running
+// in the background, and later evals fail fast until it returns.
+func (c *callback) eval(ctx context.Context, timeout time.Duration) (float64, error) {
+	select {
+	case c.running <- struct{}{}:
+	default:
+		return 0, fmt.Errorf("%w: previous call still running", ErrCallback)
+	}
+	if timeout <= 0 && ctx.Done() == nil {
+		defer func() { <-c.running }()
+		res := c.call()
+		return res.value, res.err
+	}
+	done := make(chan callbackResult, 1)
+	go func() {
+		defer func() { <-c.running }()
+		done <- c.call()
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-expired:
+		return 0, fmt.Errorf("%w: timed out after %v", ErrCallback, timeout)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (c *callback) call() (res callbackResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res.err = fmt.Errorf("%w: panic: %v", ErrCallback, r)
+		}
+	}()
+	return callbackResult{value: c.fn()}
+}
+
+// evalCallback stores the callback value of a series before it is snapshotted.
+// On failure the error is reported and the series keeps its last value,
+// unless ctx is done, which fails the whole export.
+func (s *Server) evalCallback(ctx context.Context, name string, metricState *state) {
+	cb := metricState.callback.Load()
+	if cb == nil {
+		return
+	}
+	value, err := cb.eval(ctx, s.cfg.CallbackTimeout)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		s.reportError(fmt.Errorf("%q: %w", name, err))
+		return
+	}
+	switch {
+	case metricState.Dto.Counter != nil:
+		algo.AtomicFloatStore(metricState.Dto.Counter.Value, value)
+	case metricState.Dto.Gauge != nil:
+		algo.AtomicFloatStore(metricState.Dto.Gauge.Value, value)
+	}
+}
+
+// OptCallbackTimeout bounds every CounterFunc and GaugeFunc callback during an export, zero waits forever.
+func (s *Server) OptCallbackTimeout(timeout time.Duration) *Server {
+	s.cfg.CallbackTimeout = timeout
+	return s
+}
+
+// CounterFunc registers a counter series whose value fn returns at every export.
+// labels is an interleaved key-value-key-value... slice.
+func (s *Server) CounterFunc(name string, fn func() float64, labels ...string) *counter {
+	return s.Counter(name).LabelPairs(NewLabelPairs(labels...)...).Func(fn)
+}
+
+// GaugeFunc registers a gauge series whose value fn returns at every export.
+// labels is an interleaved key-value-key-value... slice.
+func (s *Server) GaugeFunc(name string, fn func() float64, labels ...string) *gauge {
+	return s.Gauge(name).LabelPairs(NewLabelPairs(labels...)...).Func(fn)
+}
+
+// Func makes the series take the value fn returns at every export, e.g. a queue length.
+// fn must return an ever increasing value. Declare Help, Unit and labels before Func.
+func (c *counter) Func(fn func() float64) *counter {
+	c.State().callback.Store(newCallback(fn))
+	return c
+}
+
+// Func makes the series take the value fn returns at every export, e.g. a pool size.
+// Declare Help, Unit and labels before Func.
+func (g *gauge) Func(fn func() float64) *gauge {
+	g.State().callback.Store(newCallback(fn))
+	return g
+}
+
+// Func registers the series of label values given in declaration order,
+// taking the value fn returns at every export. It panics when the values count
+// does not match the label names.
+func (v *counterVec) Func(fn func() float64, values ...string) *counterVec {
+	v.with(values).state().callback.Store(newCallback(fn))
+	return v
+}
+
+// Func registers the series of label values given in declaration order,
+// taking the value fn returns at every export. It panics when the values count
+// does not match the label names.
+func (v *gaugeVec) Func(fn func() float64, values ...string) *gaugeVec {
+	v.with(values).state().callback.Store(newCallback(fn))
+	return v
+}
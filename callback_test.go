@@ -0,0 +1,100 @@
+package zpm_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestCallbackMetrics(t *testing.T) {
+	srv := zpm.NewServer().SortNames(true)
+	queue := []int{1, 2, 3}
+	srv.Gauge("queue_length").Help("queued jobs").Func(func() float64 {
+		return float64(len(queue))
+	})
+	srv.CounterFunc("cache_hits", func() float64 { return 42 }, "cache", "users")
+	pools := srv.GaugeVec("pool_size", "pool")
+	pools.Func(func() float64 { return 4 }, "db")
+	pools.Func(func() float64 { return 8 }, "http")
+
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "queue_length 3\n")
+	assert.Contains(t, res, "cache_hits{cache=\"users\"} 42\n")
+	assert.Contains(t, res, "pool_size{pool=\"db\"} 4\n")
+	assert.Contains(t, res, "pool_size{pool=\"http\"} 8\n")
+
+	queue = append(queue, 4)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "queue_length 4\n", "evaluated at every export")
+}
+
+func TestCallbackGuard(t *testing.T) {
+	var mu sync.Mutex
+	var reported []error
+	srv := zpm.NewServer().
+		OptCallbackTimeout(50 * time.Millisecond).
+		OptErrorHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		})
+	fail := false
+	srv.GaugeFunc("flaky", func() float64 {
+		if fail {
+			panic("broken pool")
+		}
+		return 7
+	})
+	release := make(chan struct{})
+	defer close(release)
+	srv.GaugeFunc("stuck", func() float64 {
+		<-release
+		return 1
+	})
+	srv.Gauge("plain").Set(1)
+
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err, "one bad callback must not break the export")
+	assert.Contains(t, res, "flaky 7\n")
+	assert.Contains(t, res, "plain 1\n")
+
+	fail = true
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "flaky 7\n", "the last value is kept")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, reported, 3, "stuck timed out, flaky panicked, stuck still running")
+	for _, err := range reported {
+		assert.True(t, errors.Is(err, zpm.ErrCallback))
+	}
+	assert.Contains(t, reported[0].Error(), "timed out")
+	assert.Contains(t, reported[1].Error(), "broken pool")
+	assert.Contains(t, reported[2].Error(), "still running")
+}
+
+func TestCallbackPanicPolicy(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().
+		OptErrorPolicy(zpm.ErrorPolicyPanic).
+		OptErrorHandler(func(err error) { reported = append(reported, err) })
+	srv.GaugeFunc("bad", func() float64 { panic("boom") })
+	srv.Gauge("plain").Set(1)
+
+	var res string
+	var err error
+	require.NotPanics(t, func() { res, err = srv.String(zpm.FmtTextPlain) }, "ErrorPolicy applies to samples, not exports")
+	require.NoError(t, err)
+	assert.Contains(t, res, "plain 1\n")
+	require.Len(t, reported, 1)
+	assert.True(t, errors.Is(reported[0], zpm.ErrCallback))
+}
@@ -0,0 +1,166 @@
+package zpm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Collector exposes metrics computed on demand, e.g. database pool stats,
+// without keeping series in the server storages. Collect is called on every
+// export and emits complete families, which are merged with the built-in ones.
+type Collector interface {
+	Collect(emit func(*dto.MetricFamily))
+}
+
+// CollectorFunc adapts a function to Collector
+type CollectorFunc func(emit func(*dto.MetricFamily))
+
+func (f CollectorFunc) Collect(emit func(*dto.MetricFamily)) {
+	f(emit)
+}
+
+// Gatherer is a source of ready families, e.g. a prometheus.Registry of client_golang.
+// Server implements it as well, so either side can be plugged into the other.
+type Gatherer interface {
+	Gather() ([]*dto.MetricFamily, error)
+}
+
+// namedCollector is a registered Collector or Gatherer
+type namedCollector struct {
+	name    string
+	gather  func() ([]*dto.MetricFamily, error)
+	running chan struct{} // holds a token while a gather is in flight
+}
+
+type gatherResult struct {
+	families []*dto.MetricFamily
+	err      error
+}
+
+// RegisterCollector adds a collector called on every export under a unique name.
+func (s *Server) RegisterCollector(name string, collector Collector) error {
+	return s.registerCollector(name, func() ([]*dto.MetricFamily, error) {
+		return collectGuarded(collector)
+	})
+}
+
+// RegisterGatherer adds a gatherer called on every export under a unique name,
+// shared with collectors and removed by UnregisterCollector.
+// Families a failing gatherer still returns are exported, its error is reported.
+// Registering a gatherer that itself gathers this server loops forever.
+func (s *Server) RegisterGatherer(name string, gatherer Gatherer) error {
+	return s.registerCollector(name, func() ([]*dto.MetricFamily, error) {
+		return gatherGuarded(gatherer)
+	})
+}
+
+func (s *Server) registerCollector(name string, gather func() ([]*dto.MetricFamily, error)) error {
+	s.collectorsMu.Lock()
+	defer s.collectorsMu.Unlock()
+	for _, c := range s.collectors {
+		if c.name == name {
+			return fmt.Errorf("%w: %q", ErrDuplicateCollector, name)
+		}
+	}
+	s.collectors = append(s.collectors, namedCollector{
+		name:    name,
+		gather:  gather,
+		running: make(chan struct{}, 1),
+	})
+	return nil
+}
+
+// UnregisterCollector removes the named collector or gatherer, telling whether it was registered.
+func (s *Server) UnregisterCollector(name string) bool {
+	s.collectorsMu.Lock()
+	defer s.collectorsMu.Unlock()
+	n := len(s.collectors)
+	s.collectors = slices.DeleteFunc(s.collectors, func(c namedCollector) bool {
+		return c.name == name
+	})
+	return len(s.collectors) < n
+}
+
+// Gather returns the families Export would write, implementing Gatherer.
+// Counters keep their names, without the OpenMetrics _total suffix.
+func (s *Server) Gather() ([]*dto.MetricFamily, error) {
+	return s.gather(context.Background())
+}
+
+// collect appends the families emitted by every collector and gatherer to dst.
+// Families without metrics are skipped, as the text format cannot encode them.
+// A panicking collector is reported and its families of this export are dropped.
+// Once ctx is done the collector in flight is given up on.
+func (s *Server) collect(ctx context.Context, dst []*dto.MetricFamily) []*dto.MetricFamily {
+	s.collectorsMu.RLock()
+	collectors := slices.Clone(s.collectors)
+	s.collectorsMu.RUnlock()
+	for _, c := range collectors {
+		if ctx.Err() != nil {
+			return dst
+		}
+		families, err := c.gatherContext(ctx)
+		if ctx.Err() != nil {
+			return dst
+		}
+		if err != nil {
+			s.reportError(fmt.Errorf("collector %q: %w", c.name, err))
+		}
+		for _, fam := range families {
+			if fam != nil && len(fam.Metric) > 0 {
+				dst = append(dst, fam)
+			}
+		}
+	}
+	return dst
+}
+
+// gatherContext runs the gather, giving up once ctx is done. A gather given up on
+// keeps running in the background, and later ones fail fast until it returns.
+func (c *namedCollector) gatherContext(ctx context.Context) ([]*dto.MetricFamily, error) {
+	select {
+	case c.running <- struct{}{}:
+	default:
+		return nil, fmt.Errorf("%w: previous gather still running", ErrCollector)
+	}
+	if ctx.Done() == nil {
+		defer func() { <-c.running }()
+		return c.gather()
+	}
+	done := make(chan gatherResult, 1)
+	go func() {
+		defer func() { <-c.running }()
+		families, err := c.gather()
+		done <- gatherResult{families: families, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.families, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func collectGuarded(collector Collector) (res []*dto.MetricFamily, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("%w: panic: %v", ErrCollector, r)
+		}
+	}()
+	collector.Collect(func(fam *dto.MetricFamily) {
+		res = append(res, fam)
+	})
+	return res, nil
+}
+
+func gatherGuarded(gatherer Gatherer) (res []*dto.MetricFamily, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("%w: panic: %v", ErrCollector, r)
+		}
+	}()
+	return gatherer.Gather()
+}
@@ -0,0 +1,152 @@
+package zpm_test
+
+import (
+	"errors"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xakepp35/zpm"
+)
+
+func gaugeFamily(name string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(value)}}},
+	}
+}
+
+func TestCollector(t *testing.T) {
+	srv := zpm.NewServer().SortNames(true)
+	srv.Counter("requests_total").Add(1)
+	open := 3.0
+	require.NoError(t, srv.RegisterCollector("db", zpm.CollectorFunc(func(emit func(*dto.MetricFamily)) {
+		emit(gaugeFamily("db_open_connections", open))
+		emit(&dto.MetricFamily{Name: proto.String("db_empty"), Type: dto.MetricType_GAUGE.Enum()})
+	})))
+	err := srv.RegisterCollector("db", zpm.CollectorFunc(func(func(*dto.MetricFamily)) {}))
+	assert.True(t, errors.Is(err, zpm.ErrDuplicateCollector))
+
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE db_open_connections gauge\ndb_open_connections 3\n"+
+		"# TYPE requests_total counter\nrequests_total 1\n", res, "merged and sorted, empty families skipped")
+
+	open = 5
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "db_open_connections 5\n", "collected at every export")
+
+	assert.True(t, srv.UnregisterCollector("db"))
+	assert.False(t, srv.UnregisterCollector("db"))
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "db_open_connections")
+}
+
+func TestCollectorDuplicate(t *testing.T) {
+	srv := zpm.NewServer()
+	srv.Gauge("pool_size").Set(1)
+	require.NoError(t, srv.RegisterCollector("pool", zpm.CollectorFunc(func(emit func(*dto.MetricFamily)) {
+		emit(gaugeFamily("pool_size", 2))
+	})))
+	_, err := srv.String(zpm.FmtTextPlain)
+	assert.True(t, errors.Is(err, zpm.ErrDuplicateFamily), "collector against storage")
+
+	srv = zpm.NewServer()
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, srv.RegisterCollector(name, zpm.CollectorFunc(func(emit func(*dto.MetricFamily)) {
+			emit(gaugeFamily("shared", 1))
+		})))
+	}
+	_, err = srv.String(zpm.FmtTextPlain)
+	assert.True(t, errors.Is(err, zpm.ErrDuplicateFamily), "collector against collector")
+}
+
+func TestCollectorPanic(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().
+		OptErrorPolicy(zpm.ErrorPolicyPanic).
+		OptErrorHandler(func(err error) { reported = append(reported, err) })
+	srv.Gauge("up").Set(1)
+	require.NoError(t, srv.RegisterCollector("broken", zpm.CollectorFunc(func(emit func(*dto.MetricFamily)) {
+		emit(gaugeFamily("half_done", 1))
+		panic("lost connection")
+	})))
+	var res string
+	var err error
+	require.NotPanics(t, func() { res, err = srv.String(zpm.FmtTextPlain) }, "ErrorPolicy applies to samples, not exports")
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE up gauge\nup 1\n", res)
+	require.Len(t, reported, 1)
+	assert.True(t, errors.Is(reported[0], zpm.ErrCollector))
+	assert.Contains(t, reported[0].Error(), `collector "broken"`)
+}
+
+type gathererFunc func() ([]*dto.MetricFamily, error)
+
+func (f gathererFunc) Gather() ([]*dto.MetricFamily, error) {
+	return f()
+}
+
+func TestGatherer(t *testing.T) {
+	legacy := zpm.NewServer()
+	legacy.Counter("legacy_requests").Add(2)
+	var _ zpm.Gatherer = legacy
+
+	families, err := legacy.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "legacy_requests", families[0].GetName())
+	assert.Equal(t, 2.0, families[0].Metric[0].GetCounter().GetValue())
+
+	srv := zpm.NewServer().SortNames(true)
+	srv.Gauge("up").Set(1)
+	require.NoError(t, srv.RegisterGatherer("legacy", legacy))
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE legacy_requests counter\nlegacy_requests 2\n# TYPE up gauge\nup 1\n", res)
+
+	err = srv.RegisterCollector("legacy", zpm.CollectorFunc(func(func(*dto.MetricFamily)) {}))
+	assert.True(t, errors.Is(err, zpm.ErrDuplicateCollector), "one namespace for collectors and gatherers")
+	assert.True(t, srv.UnregisterCollector("legacy"))
+}
+
+func TestGathererError(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().OptErrorHandler(func(err error) { reported = append(reported, err) })
+	errPartial := errors.New("one collector failed")
+	require.NoError(t, srv.RegisterGatherer("partial", gathererFunc(func() ([]*dto.MetricFamily, error) {
+		return []*dto.MetricFamily{gaugeFamily("still_here", 1)}, errPartial
+	})))
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE still_here gauge\nstill_here 1\n", res, "partial families exported")
+	require.Len(t, reported, 1)
+	assert.True(t, errors.Is(reported[0], errPartial))
+}
+
+func TestCollectorOpenMetricsKeepsFamilies(t *testing.T) {
+	srv := zpm.NewServer()
+	static := &dto.MetricFamily{
+		Name:   proto.String("static"),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Unit:   proto.String("seconds"),
+		Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(1)}}},
+	}
+	require.NoError(t, srv.RegisterCollector("static", zpm.CollectorFunc(func(emit func(*dto.MetricFamily)) {
+		emit(static)
+	})))
+	res, err := srv.String(zpm.FmtOpenMetrics)
+	require.NoError(t, err)
+	assert.Contains(t, res, "static_total 1.0\n")
+	assert.Equal(t, "static", static.GetName(), "the collector family is not renamed")
+
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE static counter\nstatic 1\n", res)
+}
@@ -1,15 +1,16 @@
 package zpm
 
 import (
+	"context"
+	"time"
+
 	dto "github.com/prometheus/client_model/go"
 	"github.com/xakepp35/zpm/algo"
 )
 
 // Counter client API interface
 type counter struct {
-	name    string
-	help    *string
-	unit    *string
+	desc
 	labels  []*dto.LabelPair
 	storage *storage
 }
@@ -24,6 +25,18 @@ func (c *counter) Unit(unit string) *counter {
 	return c
 }
 
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (c *counter) TTL(ttl time.Duration) *counter {
+	c.ttl = ttl
+	return c
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (c *counter) MaxSeries(maxSeries int) *counter {
+	c.maxSeries = maxSeries
+	return c
+}
+
 func (c *counter) LabelPairs(labelPairs ...*LabelPair) *counter {
 	c.labels = append(c.labels, labelPairs...)
 	return c
@@ -36,16 +49,26 @@ func (c *counter) Label(key, value string) *counter {
 	})
 }
 
+func (c *counter) State() *state {
+	return c.storage.demand(&c.desc, c.labels, dto.MetricType_COUNTER, c.initMetric)
+}
+
+// Bind resolves the series once, so the returned handle updates it without further lookups.
+func (c *counter) Bind() *CounterHandle {
+	builder := *c
+	handle := &CounterHandle{}
+	handle.bind(&builder)
+	return handle
+}
+
 // Please, be careful: counter should be everincreasing value!
 func (c *counter) Set(value float64) *counter {
-	metricState := c.storage.demand(c.name, c.help, c.unit, c.labels, dto.MetricType_COUNTER, c.initMetric)
-	algo.AtomicFloatStore(metricState.Dto.Counter.Value, value)
+	algo.AtomicFloatStore(c.State().Dto.Counter.Value, value)
 	return c
 }
 
 func (c *counter) Add(delta float64) *counter {
-	metricState := c.storage.demand(c.name, c.help, c.unit, c.labels, dto.MetricType_COUNTER, c.initMetric)
-	algo.AtomicFloatAdd(metricState.Dto.Counter.Value, delta)
+	algo.AtomicFloatAdd(c.State().Dto.Counter.Value, delta)
 	return c
 }
 
@@ -53,10 +76,61 @@ func (c *counter) Inc(delta int) *counter {
 	return c.Add(float64(delta))
 }
 
+// AddWithExemplar adds delta, keeping labels, e.g. a trace ID, as the latest exemplar of the series
+func (c *counter) AddWithExemplar(delta float64, labels LabelPairs) *counter {
+	addCounterExemplar(c.storage.srv, c.State(), delta, labels)
+	return c
+}
+
+// AddContext adds delta with the exemplar the server ExemplarExtractor finds in ctx, if any
+func (c *counter) AddContext(ctx context.Context, delta float64) *counter {
+	return c.AddWithExemplar(delta, c.storage.srv.extractExemplar(ctx))
+}
+
 // newMetric creates a new counter metric with labels
 func (c *counter) initMetric(metricState *state) {
 	value := float64(0)
 	metricState.Dto.Counter = &dto.Counter{
 		Value: &value,
 	}
+	metricState.Data = &exemplarSlot{}
+}
+
+func addCounterExemplar(srv *Server, metricState *state, delta float64, labels LabelPairs) {
+	algo.AtomicFloatAdd(metricState.Dto.Counter.Value, delta)
+	if len(labels) == 0 {
+		return
+	}
+	if exemplar := srv.newExemplar(labels, delta); exemplar != nil {
+		metricState.Data.(*exemplarSlot).Store(exemplar)
+	}
+}
+
+// CounterHandle is a counter series pre-bound by counter.Bind
+type CounterHandle struct {
+	binding[*counter]
+}
+
+// Please, be careful: counter should be everincreasing value!
+func (h *CounterHandle) Set(value float64) *CounterHandle {
+	algo.AtomicFloatStore(h.state().Dto.Counter.Value, value)
+	return h
+}
+
+func (h *CounterHandle) Add(delta float64) *CounterHandle {
+	algo.AtomicFloatAdd(h.state().Dto.Counter.Value, delta)
+	return h
+}
+
+func (h *CounterHandle) Inc(delta int) *CounterHandle {
+	return h.Add(float64(delta))
+}
+
+func (h *CounterHandle) AddWithExemplar(delta float64, labels LabelPairs) *CounterHandle {
+	addCounterExemplar(h.builder.storage.srv, h.state(), delta, labels)
+	return h
+}
+
+func (h *CounterHandle) AddContext(ctx context.Context, delta float64) *CounterHandle {
+	return h.AddWithExemplar(delta, h.builder.storage.srv.extractExemplar(ctx))
 }
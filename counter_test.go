@@ -1,7 +1,68 @@
 package zpm_test
 
-import "testing"
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xakepp35/zpm"
+)
 
 func BenchmarkXxx(b *testing.B) {
 
 }
+
+func BenchmarkCounterInc(b *testing.B) {
+	srv := zpm.NewServer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			srv.Counter("bench_counter").Label("l1", "v1").Inc(1)
+		}
+	})
+}
+
+func BenchmarkCounterHandleInc(b *testing.B) {
+	srv := zpm.NewServer()
+	ctr := srv.Counter("bench_counter").Label("l1", "v1").Bind()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ctr.Inc(1)
+		}
+	})
+}
+
+func TestCounterHandle(t *testing.T) {
+	srv := zpm.NewServer()
+	ctr := srv.Counter("bound_counter").Label("l1", "v1").Bind()
+	const numIter = 1000
+	var wg sync.WaitGroup
+	wg.Add(numIter)
+	for i := 0; i < numIter; i++ {
+		go func() {
+			defer wg.Done()
+			ctr.Inc(1)
+		}()
+	}
+	wg.Wait()
+	srv.Counter("bound_counter").Label("l1", "v1").Inc(1)
+	value := srv.Counter("bound_counter").Label("l1", "v1").State().Dto.Counter.GetValue()
+	assert.Equal(t, float64(numIter+1), value)
+	assert.Zero(t, testing.AllocsPerRun(100, func() { ctr.Inc(1) }))
+}
+
+func TestCounterSeriesIdentity(t *testing.T) {
+	srv := zpm.NewServer()
+	srv.Counter("ctr").Label("l1", "v1").Label("l2", "v2").Inc(1)
+	srv.Counter("ctr").Label("l2", "v2").Label("l1", "v1").Inc(1)
+	srv.Counter("ctr").Label("a", "x").Inc(1)
+	srv.Counter("ctr").Label("b", "x").Inc(1)
+	ordered := srv.Counter("ctr").Label("l2", "v2").Label("l1", "v1").State().Dto
+	assert.Equal(t, float64(2), ordered.Counter.GetValue())
+	assert.Equal(t, "l1", ordered.Label[0].GetName())
+	assert.Equal(t, "l2", ordered.Label[1].GetName())
+	assert.Equal(t, float64(1), srv.Counter("ctr").Label("a", "x").State().Dto.Counter.GetValue())
+	assert.Equal(t, float64(1), srv.Counter("ctr").Label("b", "x").State().Dto.Counter.GetValue())
+}
@@ -0,0 +1,97 @@
+package zpm
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// maxReportedErrors caps the distinct errors remembered by handleErrorOnce,
+// past it they are forgotten and reported again
+const maxReportedErrors = 1024
+
+var (
+	ErrDuplicateFamily    = errors.New("duplicate metric family")
+	ErrLabelCount         = errors.New("label values count mismatch")
+	ErrSchemaMismatch     = errors.New("metric family schema mismatch")
+	ErrInvalidName        = errors.New("invalid metric or label name")
+	ErrExemplarTooLong    = errors.New("exemplar labels too long")
+	ErrInvalidBuckets     = errors.New("invalid histogram buckets")
+	ErrInvalidQuantiles   = errors.New("invalid summary quantiles")
+	ErrCallback           = errors.New("metric callback failed")
+	ErrDuplicateCollector = errors.New("duplicate collector")
+	ErrCollector          = errors.New("metric collector failed")
+)
+
+// ErrorPolicy tells what happens to a sample whose call is invalid,
+// e.g. has an invalid name, or declares help, labels or buckets
+// that differ from its family. It applies to every such sample,
+// while the error of a call site is reported once.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyReport reports the error and records the sample anyway
+	ErrorPolicyReport ErrorPolicy = "report"
+	// ErrorPolicyDrop reports the error and drops the sample
+	ErrorPolicyDrop ErrorPolicy = "drop"
+	// ErrorPolicyPanic panics with the error
+	ErrorPolicyPanic ErrorPolicy = "panic"
+)
+
+// handleError applies the configured ErrorPolicy to err,
+// telling whether the sample that caused it must be dropped.
+func (s *Server) handleError(err error) bool {
+	if s.cfg.OnError == ErrorPolicyPanic {
+		panic(err)
+	}
+	s.reportError(err)
+	return s.cfg.OnError == ErrorPolicyDrop
+}
+
+// handleErrorOnce is handleError reporting err only the first time it is seen,
+// for errors a call site repeats on every sample, e.g. a help differing from its family.
+// The ErrorPolicy still applies to every sample.
+func (s *Server) handleErrorOnce(err error) bool {
+	if s.cfg.OnError != ErrorPolicyPanic && !s.reported.first(err.Error()) {
+		return s.cfg.OnError == ErrorPolicyDrop
+	}
+	return s.handleError(err)
+}
+
+// reportErrorOnce is reportError reporting err only the first time it is seen,
+// for errors a call site repeats on every sample that ErrorPolicy does not apply to.
+func (s *Server) reportErrorOnce(err error) {
+	if s.reported.first(err.Error()) {
+		s.reportError(err)
+	}
+}
+
+// errorSet remembers the errors already reported
+type errorSet struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// first tells whether msg is seen for the first time, remembering it
+func (e *errorSet) first(msg string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.seen[msg]; ok {
+		return false
+	}
+	if e.seen == nil || len(e.seen) >= maxReportedErrors {
+		e.seen = make(map[string]struct{})
+	}
+	e.seen[msg] = struct{}{}
+	return true
+}
+
+// reportError passes err to the error handler, or logs it. Errors that are not
+// caused by a sample, e.g. of an export, go here, as ErrorPolicy does not apply to them.
+func (s *Server) reportError(err error) {
+	if s.errorHandler != nil {
+		s.errorHandler(err)
+	} else {
+		log.Printf("zpm: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package zpm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ExemplarMaxRunes caps the total length of exemplar label names and values, as OpenMetrics does
+const ExemplarMaxRunes = 128
+
+// ExemplarExtractor pulls exemplar labels, e.g. a trace ID, out of a request context.
+// It returns no labels when the context carries none.
+type ExemplarExtractor interface {
+	ExtractExemplar(ctx context.Context) LabelPairs
+}
+
+// ExemplarExtractorFunc adapts a function to ExemplarExtractor
+type ExemplarExtractorFunc func(ctx context.Context) LabelPairs
+
+func (f ExemplarExtractorFunc) ExtractExemplar(ctx context.Context) LabelPairs {
+	return f(ctx)
+}
+
+// OptExemplarExtractor sets how the context variants, like AddContext or ObserveContext,
+// find their exemplar labels.
+func (s *Server) OptExemplarExtractor(extractor ExemplarExtractor) *Server {
+	s.exemplars = extractor
+	return s
+}
+
+// extractExemplar returns the exemplar labels of ctx, if any
+func (s *Server) extractExemplar(ctx context.Context) LabelPairs {
+	if s.exemplars == nil {
+		return nil
+	}
+	return s.exemplars.ExtractExemplar(ctx)
+}
+
+// newExemplar timestamps an exemplar of value, or returns nil when its labels are invalid.
+// An invalid exemplar is reported once and dropped, whatever the ErrorPolicy,
+// the sample itself is recorded anyway.
+func (s *Server) newExemplar(labels LabelPairs, value float64) *dto.Exemplar {
+	if err := validateExemplar(labels); err != nil {
+		s.reportErrorOnce(err)
+		return nil
+	}
+	return &dto.Exemplar{
+		Label:     labels,
+		Value:     &value,
+		Timestamp: timestamppb.New(s.now()),
+	}
+}
+
+func validateExemplar(labels LabelPairs) error {
+	var runes int
+	for _, label := range labels {
+		if !validName(label.GetName(), false) {
+			return fmt.Errorf("%w: exemplar label %q", ErrInvalidName, label.GetName())
+		}
+		runes += utf8.RuneCountInString(label.GetName()) + utf8.RuneCountInString(label.GetValue())
+	}
+	if runes > ExemplarMaxRunes {
+		// named by its labels, values differ from sample to sample
+		return fmt.Errorf("%w: labels %q exceed %d runes", ErrExemplarTooLong, labelNames(labels), ExemplarMaxRunes)
+	}
+	return nil
+}
+
+// exemplarSlot keeps the latest exemplar of a counter series
+type exemplarSlot struct {
+	atomic.Pointer[dto.Exemplar]
+}
+
+func (e *exemplarSlot) snapshot(m *Metric) {
+	if m.Counter != nil {
+		m.Counter.Exemplar = e.Load()
+	}
+}
@@ -0,0 +1,68 @@
+package zpm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+type traceIDKey struct{}
+
+func TestExemplars(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := zpm.NewServer().
+		SortNames(true).
+		OptClock(func() time.Time { return now }).
+		OptExemplarExtractor(zpm.ExemplarExtractorFunc(func(ctx context.Context) zpm.LabelPairs {
+			if traceID, ok := ctx.Value(traceIDKey{}).(string); ok {
+				return zpm.NewLabelPairs("trace_id", traceID)
+			}
+			return nil
+		}))
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "abc")
+	srv.Counter("requests").AddContext(ctx, 2)
+	srv.Counter("requests").Inc(1)
+	latency := srv.Histogram("latency").Buckets(0.1, 1).Bind()
+	latency.ObserveWithExemplar(0.5, zpm.NewLabelPairs("trace_id", "def"))
+	latency.ObserveContext(ctx, 5)
+	latency.ObserveContext(context.Background(), 0.05)
+
+	res, err := srv.String(zpm.FmtOpenMetrics)
+	require.NoError(t, err)
+	expected := []string{
+		`latency_bucket{le="0.1"} 1`,
+		`latency_bucket{le="1.0"} 2 # {trace_id="def"} 0.5 1.7e+09`,
+		`latency_bucket{le="+Inf"} 3 # {trace_id="abc"} 5.0 1.7e+09`,
+		`requests_total 3.0 # {trace_id="abc"} 2.0 1.7e+09`,
+	}
+	for _, line := range expected {
+		assert.Contains(t, res, line+"\n")
+	}
+	assert.Equal(t, 1, strings.Count(res, `latency_bucket{le="+Inf"}`))
+}
+
+func TestExemplarTooLong(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().
+		OptErrorPolicy(zpm.ErrorPolicyPanic).
+		OptErrorHandler(func(err error) {
+			reported = append(reported, err)
+		})
+	counter := srv.Counter("requests")
+	require.NotPanics(t, func() {
+		counter.AddWithExemplar(1, zpm.NewLabelPairs("trace_id", strings.Repeat("a", 200)))
+	}, "ErrorPolicy applies to samples, not their exemplars")
+	counter.AddWithExemplar(0, zpm.NewLabelPairs("trace_id", strings.Repeat("b", 300)))
+	require.Len(t, reported, 1, "an invalid call site is reported once")
+	assert.True(t, errors.Is(reported[0], zpm.ErrExemplarTooLong))
+	snapshot := counter.State().Snapshot().Counter
+	assert.Equal(t, 1.0, snapshot.GetValue(), "the sample is recorded anyway")
+	assert.Nil(t, snapshot.GetExemplar())
+}
@@ -0,0 +1,84 @@
+package zpm
+
+import (
+	"context"
+	"time"
+)
+
+// touch records a write to a series that expires when idle
+func (s *state) touch() {
+	s.lastWrite.Store(s.family.storage.srv.now().UnixMilli())
+}
+
+// idle tells whether the series has not been written for longer than its ttl.
+// Callback series are never idle, exports keep them up to date.
+func (s *state) idle(nowMs int64) bool {
+	return s.ttl > 0 && nowMs-s.lastWrite.Load() > s.ttl.Milliseconds() && s.callback.Load() == nil
+}
+
+// claimIdle marks an idle series deleted, unless a write touches it meanwhile.
+// Writers touch before checking deleted, so either the write is seen here
+// or the writer sees the series deleted and resolves it again.
+func (s *state) claimIdle(nowMs int64) bool {
+	if !s.idle(nowMs) {
+		return false
+	}
+	s.deleted.Store(true)
+	if s.idle(nowMs) {
+		return true
+	}
+	s.deleted.Store(false)
+	return false
+}
+
+// expire evicts idle series, telling how many were removed.
+func (s *storage) expire(now time.Time) int {
+	nowMs := now.UnixMilli()
+	s.mu.RLock()
+	found := false
+	for _, metricState := range s.metrics {
+		if metricState.idle(nowMs) {
+			found = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if !found {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for _, fam := range s.families {
+		removed += s.deleteFamilySeries(fam, func(metricState *state) bool {
+			return metricState.claimIdle(nowMs)
+		})
+	}
+	return removed
+}
+
+// Expire evicts the series not written for longer than their TTL,
+// telling how many were removed. Export calls it before every gather.
+func (s *Server) Expire() int {
+	now := s.now()
+	removed := 0
+	for _, st := range s.storages() {
+		removed += st.expire(now)
+	}
+	return removed
+}
+
+// RunJanitor evicts idle series every interval, until ctx is done.
+// Export evicts lazily anyway, the janitor keeps memory bounded between scrapes.
+func (s *Server) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Expire()
+		}
+	}
+}
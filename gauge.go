@@ -1,15 +1,15 @@
 package zpm
 
 import (
+	"time"
+
 	dto "github.com/prometheus/client_model/go"
 	"github.com/xakepp35/zpm/algo"
 )
 
 // Gauge client API interface
 type gauge struct {
-	name    string
-	help    *string
-	unit    *string
+	desc
 	labels  []*dto.LabelPair
 	storage *storage
 }
@@ -24,6 +24,18 @@ func (g *gauge) Unit(unit string) *gauge {
 	return g
 }
 
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (g *gauge) TTL(ttl time.Duration) *gauge {
+	g.ttl = ttl
+	return g
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (g *gauge) MaxSeries(maxSeries int) *gauge {
+	g.maxSeries = maxSeries
+	return g
+}
+
 func (c *gauge) LabelPairs(labelPairs ...*LabelPair) *gauge {
 	c.labels = append(c.labels, labelPairs...)
 	return c
@@ -36,15 +48,25 @@ func (c *gauge) Label(key, value string) *gauge {
 	})
 }
 
+func (g *gauge) State() *state {
+	return g.storage.demand(&g.desc, g.labels, dto.MetricType_GAUGE, g.initMetric)
+}
+
+// Bind resolves the series once, so the returned handle updates it without further lookups.
+func (g *gauge) Bind() *GaugeHandle {
+	builder := *g
+	handle := &GaugeHandle{}
+	handle.bind(&builder)
+	return handle
+}
+
 func (g *gauge) Set(value float64) *gauge {
-	metricState := g.storage.demand(g.name, g.help, g.unit, g.labels, dto.MetricType_GAUGE, g.initMetric)
-	algo.AtomicFloatStore(metricState.Dto.Gauge.Value, value)
+	algo.AtomicFloatStore(g.State().Dto.Gauge.Value, value)
 	return g
 }
 
 func (g *gauge) Add(delta float64) *gauge {
-	metricState := g.storage.demand(g.name, g.help, g.unit, g.labels, dto.MetricType_GAUGE, g.initMetric)
-	algo.AtomicFloatAdd(metricState.Dto.Gauge.Value, delta)
+	algo.AtomicFloatAdd(g.State().Dto.Gauge.Value, delta)
 	return g
 }
 
@@ -62,3 +84,26 @@ func (g *gauge) initMetric(metricState *state) {
 		Value: new(float64),
 	}
 }
+
+// GaugeHandle is a gauge series pre-bound by gauge.Bind
+type GaugeHandle struct {
+	binding[*gauge]
+}
+
+func (h *GaugeHandle) Set(value float64) *GaugeHandle {
+	algo.AtomicFloatStore(h.state().Dto.Gauge.Value, value)
+	return h
+}
+
+func (h *GaugeHandle) Add(delta float64) *GaugeHandle {
+	algo.AtomicFloatAdd(h.state().Dto.Gauge.Value, delta)
+	return h
+}
+
+func (h *GaugeHandle) Inc(delta int) *GaugeHandle {
+	return h.Add(float64(delta))
+}
+
+func (h *GaugeHandle) Dec(delta int) *GaugeHandle {
+	return h.Add(float64(-delta))
+}
@@ -12,7 +12,9 @@
 package zpm
 
 import (
+	"context"
 	"io"
+	"net/http"
 
 	"github.com/prometheus/common/expfmt"
 )
@@ -105,6 +107,82 @@ func Summary(name string) *summary {
 	return Srv.Summary(name)
 }
 
+// CounterVec ➕
+//
+//	@Summary Creates a counter family with declared label names.
+//	@Description Help, unit and label names are declared once, With(values...) returns a cached pre-bound counter handle.
+//	@Tags metrics
+//	@Param name query string true "Name of the counter metric"
+//	@Param labelNames query []string false "Ordered label names"
+//	@Usage `requests := zpm.CounterVec("http_requests_total", "method", "path").Help("http requests")`, then `requests.With("GET", "/api").Inc(1)`.
+//	@Misuse ❌ Passing a different number of values than declared label names (With panics, Get returns an error).
+//	@Pros ✅ Family shape is declared in one place, lookups are cached.
+func CounterVec(name string, labelNames ...string) *counterVec {
+	return Srv.CounterVec(name, labelNames...)
+}
+
+// GaugeVec ⚖️
+//
+//	@Summary Creates a gauge family with declared label names.
+//	@Description Help, unit and label names are declared once, With(values...) returns a cached pre-bound gauge handle.
+//	@Tags metrics
+//	@Param name query string true "Name of the gauge metric"
+//	@Param labelNames query []string false "Ordered label names"
+//	@Misuse ❌ Passing a different number of values than declared label names (With panics, Get returns an error).
+func GaugeVec(name string, labelNames ...string) *gaugeVec {
+	return Srv.GaugeVec(name, labelNames...)
+}
+
+// HistogramVec 📊
+//
+//	@Summary Creates a histogram family with declared label names.
+//	@Description Help, unit, buckets and label names are declared once, With(values...) returns a cached pre-bound histogram handle.
+//	@Tags metrics
+//	@Param name query string true "Name of the histogram metric"
+//	@Param labelNames query []string false "Ordered label names"
+//	@Misuse ❌ Passing a different number of values than declared label names (With panics, Get returns an error).
+func HistogramVec(name string, labelNames ...string) *histogramVec {
+	return Srv.HistogramVec(name, labelNames...)
+}
+
+// SummaryVec 💡
+//
+//	@Summary Creates a summary family with declared label names.
+//	@Description Help, unit, quantiles and label names are declared once, With(values...) returns a cached pre-bound summary handle.
+//	@Tags metrics
+//	@Param name query string true "Name of the summary metric"
+//	@Param labelNames query []string false "Ordered label names"
+//	@Misuse ❌ Passing a different number of values than declared label names (With panics, Get returns an error).
+func SummaryVec(name string, labelNames ...string) *summaryVec {
+	return Srv.SummaryVec(name, labelNames...)
+}
+
+// CounterFunc ➕
+//
+//	@Summary Registers a counter series computed by a callback at every export.
+//	@Description The callback runs during Export, guarded against panics and bounded by the server CallbackTimeout. On failure the error is reported and the last value is exported.
+//	@Tags metrics
+//	@Param name query string true "Name of the counter metric"
+//	@Param labels query []string false "Interleaved label names and values"
+//	@Usage Exposing totals another library already counts.
+//	@Misuse ❌ Returning a value that decreases (use GaugeFunc for that).
+func CounterFunc(name string, fn func() float64, labels ...string) *counter {
+	return Srv.CounterFunc(name, fn, labels...)
+}
+
+// GaugeFunc ⚖️
+//
+//	@Summary Registers a gauge series computed by a callback at every export.
+//	@Description The callback runs during Export, guarded against panics and bounded by the server CallbackTimeout. On failure the error is reported and the last value is exported.
+//	@Tags metrics
+//	@Param name query string true "Name of the gauge metric"
+//	@Param labels query []string false "Interleaved label names and values"
+//	@Usage Queue lengths, pool sizes, cache entry counts, without a goroutine polling them.
+//	@Tricks 🔍 Declare help first: zpm.Gauge(name).Help(help).Func(fn).
+func GaugeFunc(name string, fn func() float64, labels ...string) *gauge {
+	return Srv.GaugeFunc(name, fn, labels...)
+}
+
 // SortNames sets whether metric names should be ordered predictably during export.
 //	@Summary Sets sorting behavior for metric names during export.
 //	@Tags configuration
@@ -114,6 +192,83 @@ func SortNames(sortNames bool) *Server {
 	return Srv.SortNames(sortNames)
 }
 
+// Delete 🗑️
+//
+//	@Summary Removes a single series.
+//	@Description Removes the series of the named family with exactly the given labels, passed as an interleaved key-value-key-value... list.
+//	@Tags configuration
+//	@Param name query string true "Name of the metric family"
+//	@Param labels query []string false "Interleaved label names and values"
+//	@Usage Dropping per-worker series once the worker is gone.
+//	@Tricks 🔍 Pre-bound handles of a deleted series keep working, they recreate it on the next update.
+func Delete(name string, labels ...string) bool {
+	return Srv.Delete(name, labels...)
+}
+
+// DeleteMatching 🗑️
+//
+//	@Summary Removes every series having the given labels.
+//	@Description Partial match: any series of the named family carrying all the given label pairs is removed.
+//	@Tags configuration
+//	@Param name query string true "Name of the metric family"
+//	@Param labels query []string false "Interleaved label names and values"
+func DeleteMatching(name string, labels ...string) int {
+	return Srv.DeleteMatching(name, labels...)
+}
+
+// Reset 🗑️
+//
+//	@Summary Removes all series of a family.
+//	@Description The family schema (help, unit, label names, buckets) stays registered.
+//	@Tags configuration
+//	@Param name query string true "Name of the metric family"
+func Reset(name string) int {
+	return Srv.Reset(name)
+}
+
+// Unregister 🗑️
+//
+//	@Summary Removes a family with all its series.
+//	@Description The name may then be registered again with another schema.
+//	@Tags configuration
+//	@Param name query string true "Name of the metric family"
+func Unregister(name string) bool {
+	return Srv.Unregister(name)
+}
+
+// RegisterCollector 🧩
+//
+//	@Summary Adds a collector called on every export under a unique name.
+//	@Description The emitted families are merged with the built-in ones, a family name exported twice fails the export with ErrDuplicateFamily.
+//	@Tags configuration
+//	@Param name query string true "Unique name of the collector"
+//	@Usage Database pool stats, third-party library counters, computed on demand.
+//	@Misuse ❌ Emitting a family also registered through zpm.Counter and friends.
+func RegisterCollector(name string, collector Collector) error {
+	return Srv.RegisterCollector(name, collector)
+}
+
+// RegisterGatherer 🧩
+//
+//	@Summary Adds an external gatherer called on every export under a unique name.
+//	@Description Any Gather() ([]*dto.MetricFamily, error) source fits, e.g. a prometheus.Registry, so one /metrics endpoint serves both. The families it still returns along with an error are exported, the error is reported.
+//	@Tags configuration
+//	@Param name query string true "Unique name of the gatherer, shared with collectors"
+//	@Usage Migrating from client_golang: zpm.RegisterGatherer("legacy", prometheus.DefaultGatherer).
+//	@Misuse ❌ Registering a registry that itself gathers zpm.Srv, exports would recurse forever.
+func RegisterGatherer(name string, gatherer Gatherer) error {
+	return Srv.RegisterGatherer(name, gatherer)
+}
+
+// UnregisterCollector 🗑️
+//
+//	@Summary Removes a collector or gatherer registered by RegisterCollector or RegisterGatherer.
+//	@Tags configuration
+//	@Param name query string true "Name of the collector"
+func UnregisterCollector(name string) bool {
+	return Srv.UnregisterCollector(name)
+}
+
 // String ➕
 //
 //	@Summary Exports metrics as a string in the specified format.
@@ -142,5 +297,28 @@ func Bytes(format expfmt.Format, opts ...expfmt.EncoderOption) (string, error) {
 	return Srv.String(format, opts...)
 }
 
+// Handler 📡
+//
+//	@Summary Serves the metrics over http.
+//	@Description The format is negotiated from the Accept header (text, delimited protobuf), the response is gzipped when the client accepts it.
+//	@Tags metrics
+//	@Produce text/plain
+//	@Usage `http.Handle("/metrics", zpm.Handler())`
+//	@Tricks 🛠️ Use Srv.Handler(zpm.HandlerOpts{EnableOpenMetrics: true}) to let Prometheus negotiate OpenMetrics.
+func Handler() http.Handler {
+	return Srv.Handler(HandlerOpts{})
+}
+
+// ListenAndServe 📡
+//
+//	@Summary Serves the metrics at /metrics until the context is done.
+//	@Description Runs a dedicated http server, shutting it down gracefully once ctx is done.
+//	@Tags metrics
+//	@Param addr query string true "Address to listen on, e.g. ':9090'"
+//	@Usage `go zpm.ListenAndServe(ctx, ":9090")` for simple services without their own http server.
+func ListenAndServe(ctx context.Context, addr string) error {
+	return Srv.ListenAndServe(ctx, addr)
+}
+
 // singletone
 var Srv = NewServer()
\ No newline at end of file
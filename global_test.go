@@ -43,6 +43,14 @@ func TestHistograms(t *testing.T) {
 }
 
 func TestCounters(t *testing.T) {
+	defer func(srv *zpm.Server) { zpm.Srv = srv }(zpm.Srv)
+	var mu sync.Mutex
+	var errs []error
+	zpm.Srv = zpm.NewServer().OptErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
 	const numIter = 1000
 	var wg sync.WaitGroup
 	wg.Add(4 * numIter)
@@ -87,4 +95,7 @@ func TestCounters(t *testing.T) {
 	fmt.Printf("%s", res)
 	assert.NoError(t, err)
 	assert.NotEqual(t, "", res)
+	if assert.Len(t, errs, 1, "the ctr_2 help mismatch is reported once") {
+		assert.ErrorIs(t, errs[0], zpm.ErrSchemaMismatch)
+	}
 }
@@ -0,0 +1,146 @@
+package zpm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	MetricsPath = "/metrics"
+
+	shutdownTimeout   = 5 * time.Second
+	readHeaderTimeout = 10 * time.Second
+)
+
+// HandlerOpts tunes the metrics http.Handler
+type HandlerOpts struct {
+	// EnableOpenMetrics lets clients negotiate the OpenMetrics text format
+	EnableOpenMetrics bool `json:"enable_open_metrics"`
+	// DisableCompression never gzips responses, even when clients accept it
+	DisableCompression bool `json:"disable_compression"`
+	// EncoderOptions are passed to the encoder, e.g. expfmt.WithCreatedLines()
+	EncoderOptions []expfmt.EncoderOption `json:"-"`
+	// Timeout bounds an export, past it the response is 503. Zero waits
+	// as long as the request lasts.
+	Timeout time.Duration `json:"timeout"`
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Handler serves the metrics in the format negotiated from the Accept header,
+// gzipped when the client accepts it.
+func (s *Server) Handler(opts HandlerOpts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		format := expfmt.Negotiate(r.Header)
+		if opts.EnableOpenMetrics {
+			format = expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		}
+		ctx := r.Context()
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+		var buf bytes.Buffer
+		if err := s.ExportContext(ctx, &buf, format, opts.EncoderOptions...); err != nil {
+			http.Error(w, "zpm: "+err.Error(), exportErrorStatus(err))
+			return
+		}
+		header := w.Header()
+		header.Set("Content-Type", string(format))
+		header.Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if opts.DisableCompression || !acceptsGzip(r.Header) {
+			header.Set("Content-Length", strconv.Itoa(buf.Len()))
+			_, _ = buf.WriteTo(w)
+			return
+		}
+		header.Set("Content-Encoding", "gzip")
+		gz := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(gz)
+		gz.Reset(w)
+		_, _ = buf.WriteTo(gz)
+		_ = gz.Close()
+	})
+}
+
+// exportErrorStatus maps an export error to the response status code:
+// 503 for an export given up on, which a later scrape may complete,
+// 500 otherwise, e.g. ErrDuplicateFamily or an encoder failure,
+// as the registry cannot be exported until it is fixed.
+func exportErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// acceptsGzip tells whether the Accept-Encoding header allows gzip
+func acceptsGzip(header http.Header) bool {
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !found {
+				return true
+			}
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ListenAndServe serves the metrics at MetricsPath on addr until ctx is done,
+// then shuts the http server down gracefully.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, s.Handler(HandlerOpts{}))
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package zpm_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestHandler(t *testing.T) {
+	srv := zpm.NewServer()
+	srv.Counter("handled_total").Inc(1)
+	handler := srv.Handler(zpm.HandlerOpts{EnableOpenMetrics: true})
+
+	req := httptest.NewRequest(http.MethodGet, zpm.MetricsPath, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, expfmt.TypeTextPlain, expfmt.Format(rec.Header().Get("Content-Type")).FormatType())
+	assert.Contains(t, rec.Body.String(), "handled_total 1")
+
+	req = httptest.NewRequest(http.MethodGet, zpm.MetricsPath, nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/openmetrics-text")
+	gz, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(body, []byte("# EOF\n")))
+
+	req = httptest.NewRequest(http.MethodGet, zpm.MetricsPath, nil)
+	req.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, expfmt.TypeProtoDelim, expfmt.Format(rec.Header().Get("Content-Type")).FormatType())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, zpm.MetricsPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	srv.Gauge("handled_total").Set(1)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, zpm.MetricsPath, nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code, "a duplicate family is a misconfiguration")
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().
+		OptCallbackTimeout(0).
+		OptErrorHandler(func(err error) { reported = append(reported, err) })
+	release := make(chan struct{})
+	defer close(release)
+	srv.GaugeFunc("stuck", func() float64 {
+		<-release
+		return 1
+	})
+	handler := srv.Handler(zpm.HandlerOpts{Timeout: 50 * time.Millisecond})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, zpm.MetricsPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Empty(t, reported, "an export given up on is not a callback failure")
+
+	hung := zpm.NewServer()
+	require.NoError(t, hung.RegisterCollector("hung", zpm.CollectorFunc(func(func(*dto.MetricFamily)) {
+		<-release
+	})))
+	handler = hung.Handler(zpm.HandlerOpts{Timeout: 50 * time.Millisecond})
+	startedAt := time.Now()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, zpm.MetricsPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Less(t, time.Since(startedAt), time.Second, "a hung collector is given up on at the deadline")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := srv.ExportContext(ctx, io.Discard, zpm.FmtTextPlain)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestListenAndServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	srv := zpm.NewServer()
+	srv.Counter("served_total").Inc(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe(ctx, addr)
+	}()
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = http.Get("http://" + addr + zpm.MetricsPath)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Contains(t, string(body), "served_total 1")
+	cancel()
+	assert.NoError(t, <-errCh)
+}
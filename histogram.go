@@ -1,7 +1,13 @@
 package zpm
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
 	"sync/atomic"
+	"time"
 
 	dto "github.com/prometheus/client_model/go"
 	"github.com/xakepp35/zpm/algo"
@@ -9,12 +15,10 @@ import (
 
 // Histogram client API
 type histogram struct {
-	name    string
-	help    *string
-	unit    *string
+	desc
 	labels  []*dto.LabelPair
-	buckets []float64
 	storage *storage
+	native  nativeConfig
 }
 
 func (h *histogram) Help(help string) *histogram {
@@ -27,6 +31,18 @@ func (h *histogram) Unit(unit string) *histogram {
 	return h
 }
 
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (h *histogram) TTL(ttl time.Duration) *histogram {
+	h.ttl = ttl
+	return h
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (h *histogram) MaxSeries(maxSeries int) *histogram {
+	h.maxSeries = maxSeries
+	return h
+}
+
 func (c *histogram) LabelPairs(labelPairs ...*LabelPair) *histogram {
 	c.labels = append(c.labels, labelPairs...)
 	return c
@@ -39,44 +55,223 @@ func (c *histogram) Label(key, value string) *histogram {
 	})
 }
 
-// Buckets - please provide sorted bucket values in ascending order!
+// Buckets sets the classic bucket bounds, see LinearBuckets and ExponentialBuckets.
+// Bounds are sorted and deduplicated, +Inf is implied. NaN bounds are reported and dropped.
+// Without Buckets a histogram uses DefaultBuckets, unless it is Native.
 func (h *histogram) Buckets(buckets ...float64) *histogram {
-	h.buckets = buckets
+	bounds, err := makeBuckets(buckets)
+	if err != nil {
+		h.storage.srv.handleErrorOnce(fmt.Errorf("histogram %q: %w", h.name, err))
+	}
+	h.buckets = bounds
 	return h
 }
 
+// Native enables sparse exponential buckets, growing by 2^(2^-schema),
+// schema ranges from NativeMinSchema to NativeMaxSchema.
+// Classic buckets are still emitted alongside when Buckets are set.
+func (h *histogram) Native(schema int32) *histogram {
+	h.native.enabled = true
+	h.defaultBuckets = []float64{} // no classic buckets
+	h.native.schema = clampSchema(schema)
+	return h
+}
+
+// NativeFactor enables native buckets with the finest schema growing by at most factor, e.g. 1.1
+func (h *histogram) NativeFactor(factor float64) *histogram {
+	return h.Native(nativeSchema(factor))
+}
+
+// NativeMaxBuckets halves the native resolution once a series populates more buckets,
+// defaults to NativeMaxBuckets
+func (h *histogram) NativeMaxBuckets(maxBuckets uint32) *histogram {
+	h.native.maxBuckets = maxBuckets
+	return h
+}
+
+// NativeZeroThreshold sets the width of the native zero bucket, defaults to NativeZeroThreshold
+func (h *histogram) NativeZeroThreshold(threshold float64) *histogram {
+	h.native.zeroThreshold = &threshold
+	return h
+}
+
+func (h *histogram) State() *state {
+	return h.storage.demand(&h.desc, h.labels, dto.MetricType_HISTOGRAM, h.initMetric)
+}
+
+// Bind resolves the series once, so the returned handle updates it without further lookups.
+func (h *histogram) Bind() *HistogramHandle {
+	builder := *h
+	handle := &HistogramHandle{}
+	handle.bind(&builder)
+	return handle
+}
+
 func (h *histogram) Observe(value float64) *histogram {
-	metricState := h.storage.demand(h.name, h.help, h.unit, h.labels, dto.MetricType_HISTOGRAM, h.initMetric)
-	updateHistogram(metricState.Dto.Histogram, value)
+	observeHistogram(h.State(), value)
+	return h
+}
+
+// ObserveWithExemplar observes value, keeping labels, e.g. a trace ID,
+// as the latest exemplar of the bucket value falls into
+func (h *histogram) ObserveWithExemplar(value float64, labels LabelPairs) *histogram {
+	observeHistogramExemplar(h.storage.srv, h.State(), value, labels)
 	return h
 }
 
+// ObserveContext observes value with the exemplar the server ExemplarExtractor finds in ctx, if any
+func (h *histogram) ObserveContext(ctx context.Context, value float64) *histogram {
+	return h.ObserveWithExemplar(value, h.storage.srv.extractExemplar(ctx))
+}
+
 func (h *histogram) initMetric(metricState *state) {
-	metricState.Dto.Histogram = &dto.Histogram{
-		SampleCount: new(uint64),
-		SampleSum:   new(float64),
-		Bucket:      makeBuckets(h.buckets),
+	bounds := h.buckets
+	if bounds == nil {
+		bounds = h.defaultBuckets
 	}
+	metricState.Data = newHistogramCounts(bounds, h.native)
+}
+
+// histogramCounts is the live data of a histogram series
+type histogramCounts struct {
+	hotCold[*histogramShard]
+	bounds        []float64
+	exemplars     []atomic.Pointer[dto.Exemplar] // latest per bucket, +Inf last
+	native        bool
+	zeroThreshold float64
+	maxBuckets    uint32
 }
 
-func updateHistogram(h *dto.Histogram, value float64) {
-	atomic.AddUint64(h.SampleCount, 1)
-	algo.AtomicFloatAdd(h.SampleSum, value)
-	for _, b := range h.Bucket {
-		if value <= *b.UpperBound {
-			atomic.AddUint64(b.CumulativeCount, 1)
+type histogramShard struct {
+	count   atomic.Uint64
+	sum     float64         // written atomically
+	buckets []atomic.Uint64 // per bucket, cumulated by snapshots
+	native  *nativeShard    // nil unless native
+}
+
+func newHistogramCounts(bounds []float64, native nativeConfig) *histogramCounts {
+	res := &histogramCounts{
+		bounds:        slices.Clone(bounds),
+		exemplars:     make([]atomic.Pointer[dto.Exemplar], len(bounds)+1),
+		native:        native.enabled,
+		zeroThreshold: NativeZeroThreshold,
+		maxBuckets:    NativeMaxBuckets,
+	}
+	if native.zeroThreshold != nil {
+		res.zeroThreshold = *native.zeroThreshold
+	}
+	if native.maxBuckets > 0 {
+		res.maxBuckets = native.maxBuckets
+	}
+	for i := range res.shards {
+		res.shards[i] = &histogramShard{
+			buckets: make([]atomic.Uint64, len(bounds)),
+		}
+		if res.native {
+			res.shards[i].native = newNativeShard(native.schema)
 		}
 	}
+	return res
+}
+
+// observeHistogram counts value in the single bucket it falls into,
+// returning the bucket index, len(bounds) for values past the last bound.
+func observeHistogram(metricState *state, value float64) int {
+	h := metricState.Data.(*histogramCounts)
+	idx := sort.SearchFloat64s(h.bounds, value)
+	hot := h.hot()
+	populated := h.native && hot.native.observe(value, h.zeroThreshold)
+	if idx < len(hot.buckets) {
+		hot.buckets[idx].Add(1)
+	}
+	algo.AtomicFloatAdd(&hot.sum, value)
+	hot.count.Add(1)
+	if populated && hot.native.populated.Load() > h.maxBuckets {
+		h.limitBuckets()
+	}
+	return idx
+}
+
+func observeHistogramExemplar(srv *Server, metricState *state, value float64, labels LabelPairs) {
+	idx := observeHistogram(metricState, value)
+	if len(labels) == 0 {
+		return
+	}
+	if exemplar := srv.newExemplar(labels, value); exemplar != nil {
+		metricState.Data.(*histogramCounts).exemplars[idx].Store(exemplar)
+	}
 }
 
-func makeBuckets(bounds []float64) []*dto.Bucket {
-	counts := make([]uint64, len(bounds))
-	buckets := make([]*dto.Bucket, len(bounds))
-	for i := range bounds {
-		buckets[i] = &dto.Bucket{
-			UpperBound:      &bounds[i],
-			CumulativeCount: &counts[i],
+func (h *histogramCounts) snapshot(m *dto.Metric) {
+	res := &dto.Histogram{
+		Bucket: make([]*dto.Bucket, len(h.bounds)),
+	}
+	h.read(func(cold *histogramShard) {
+		res.SampleCount = ptr(cold.count.Load())
+		res.SampleSum = ptr(algo.AtomicFloatLoad(&cold.sum))
+		var cumulative uint64
+		for i := range h.bounds {
+			cumulative += cold.buckets[i].Load()
+			res.Bucket[i] = &dto.Bucket{
+				UpperBound:      &h.bounds[i],
+				CumulativeCount: ptr(cumulative),
+			}
+		}
+		if h.native {
+			cold.native.fill(res, h.zeroThreshold)
+		}
+	})
+	for i, bucket := range res.Bucket {
+		bucket.Exemplar = h.exemplars[i].Load()
+	}
+	if exemplar := h.exemplars[len(h.bounds)].Load(); exemplar != nil && (len(h.bounds) > 0 || !h.native) {
+		// values past the last bound keep their exemplar on an explicit +Inf bucket
+		res.Bucket = append(res.Bucket, &dto.Bucket{
+			UpperBound:      ptr(math.Inf(1)),
+			CumulativeCount: res.SampleCount,
+			Exemplar:        exemplar,
+		})
+	}
+	if h.native {
+		for i := range h.exemplars {
+			if exemplar := h.exemplars[i].Load(); exemplar != nil {
+				res.Exemplars = append(res.Exemplars, exemplar)
+			}
 		}
 	}
-	return buckets
+	m.Histogram = res
+}
+
+func (s *histogramShard) completed() uint64 {
+	return s.count.Load()
+}
+
+func (s *histogramShard) merge(cold *histogramShard) {
+	for i := range cold.buckets {
+		s.buckets[i].Add(cold.buckets[i].Swap(0))
+	}
+	if s.native != nil {
+		s.native.merge(cold.native)
+	}
+	algo.AtomicFloatAdd(&s.sum, algo.AtomicFloatSwap(&cold.sum, 0))
+	s.count.Add(cold.count.Swap(0))
+}
+
+// HistogramHandle is a histogram series pre-bound by histogram.Bind
+type HistogramHandle struct {
+	binding[*histogram]
+}
+
+func (h *HistogramHandle) Observe(value float64) *HistogramHandle {
+	observeHistogram(h.state(), value)
+	return h
+}
+
+func (h *HistogramHandle) ObserveWithExemplar(value float64, labels LabelPairs) *HistogramHandle {
+	observeHistogramExemplar(h.builder.storage.srv, h.state(), value, labels)
+	return h
+}
+
+func (h *HistogramHandle) ObserveContext(ctx context.Context, value float64) *HistogramHandle {
+	return h.ObserveWithExemplar(value, h.builder.storage.srv.extractExemplar(ctx))
 }
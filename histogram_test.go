@@ -0,0 +1,151 @@
+package zpm_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestHistogramSnapshotConsistency(t *testing.T) {
+	srv := zpm.NewServer()
+	hist := srv.Histogram("consistent").Buckets(1, 2).Bind()
+	const numWriters, numIter = 8, 2000
+	var wg sync.WaitGroup
+	wg.Add(numWriters)
+	for i := 0; i < numWriters; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numIter; j++ {
+				hist.Observe(1)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for exporting := true; exporting; {
+		select {
+		case <-done:
+			exporting = false
+		default:
+		}
+		_, err := srv.String(zpm.FmtTextPlain)
+		require.NoError(t, err)
+		snapshot := srv.Histogram("consistent").State().Snapshot().Histogram
+		count := snapshot.GetSampleCount()
+		assert.Equal(t, count, snapshot.Bucket[0].GetCumulativeCount())
+		assert.Equal(t, count, snapshot.Bucket[1].GetCumulativeCount())
+		assert.Equal(t, float64(count), snapshot.GetSampleSum())
+	}
+	snapshot := srv.Histogram("consistent").State().Snapshot().Histogram
+	assert.Equal(t, uint64(numWriters*numIter), snapshot.GetSampleCount())
+}
+
+func TestHistogramNative(t *testing.T) {
+	srv := zpm.NewServer()
+	hist := srv.Histogram("native").Native(0).Buckets(1)
+	for _, v := range []float64{0, 1, 1.5, 3, -2} {
+		hist.Observe(v)
+	}
+	snapshot := hist.State().Snapshot().Histogram
+	assert.Equal(t, int32(0), snapshot.GetSchema())
+	assert.Equal(t, zpm.NativeZeroThreshold, snapshot.GetZeroThreshold())
+	assert.Equal(t, uint64(1), snapshot.GetZeroCount())
+	// schema 0: bucket i spans (2^(i-1), 2^i], so 1 -> 0, 1.5 -> 1, 3 -> 2
+	require.Len(t, snapshot.GetPositiveSpan(), 1)
+	assert.Equal(t, int32(0), snapshot.GetPositiveSpan()[0].GetOffset())
+	assert.Equal(t, uint32(3), snapshot.GetPositiveSpan()[0].GetLength())
+	assert.Equal(t, []int64{1, 0, 0}, snapshot.GetPositiveDelta())
+	require.Len(t, snapshot.GetNegativeSpan(), 1)
+	assert.Equal(t, int32(1), snapshot.GetNegativeSpan()[0].GetOffset())
+	assert.Equal(t, []int64{1}, snapshot.GetNegativeDelta())
+	// classic buckets are kept alongside
+	require.Len(t, snapshot.GetBucket(), 1)
+	assert.Equal(t, uint64(3), snapshot.GetBucket()[0].GetCumulativeCount())
+}
+
+func TestHistogramNativeEmpty(t *testing.T) {
+	srv := zpm.NewServer()
+	snapshot := srv.Histogram("native_empty").NativeFactor(1.1).State().Snapshot().Histogram
+	assert.Equal(t, int32(3), snapshot.GetSchema())
+	require.Len(t, snapshot.GetPositiveSpan(), 1, "no-op span")
+	assert.Equal(t, uint32(0), snapshot.GetPositiveSpan()[0].GetLength())
+}
+
+func TestHistogramNativeResolutionReduction(t *testing.T) {
+	srv := zpm.NewServer()
+	hist := srv.Histogram("native_limited").Native(3).NativeMaxBuckets(4).Bind()
+	const numObservations = 1000
+	for i := 1; i <= numObservations; i++ {
+		hist.Observe(float64(i))
+	}
+	snapshot := srv.Histogram("native_limited").State().Snapshot().Histogram
+	assert.Less(t, snapshot.GetSchema(), int32(3))
+	var buckets int
+	var total, count int64
+	for _, span := range snapshot.GetPositiveSpan() {
+		buckets += int(span.GetLength())
+	}
+	for _, delta := range snapshot.GetPositiveDelta() {
+		count += delta
+		total += count
+	}
+	assert.LessOrEqual(t, buckets, 4)
+	assert.Equal(t, int64(numObservations), total)
+	assert.Equal(t, uint64(numObservations), snapshot.GetSampleCount())
+}
+
+func TestHistogramNativeConcurrent(t *testing.T) {
+	srv := zpm.NewServer()
+	hist := srv.Histogram("native_concurrent").Native(8).NativeMaxBuckets(20).Bind()
+	const numWriters, numIter = 8, 2000
+	var wg sync.WaitGroup
+	wg.Add(numWriters)
+	for i := 0; i < numWriters; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= numIter; j++ {
+				hist.Observe(float64(j))
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		_, err := srv.String(zpm.FmtTextPlain)
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	snapshot := srv.Histogram("native_concurrent").State().Snapshot().Histogram
+	var total, count int64
+	for _, delta := range snapshot.GetPositiveDelta() {
+		count += delta
+		total += count
+	}
+	assert.Equal(t, int64(numWriters*numIter), total)
+	assert.Equal(t, uint64(numWriters*numIter), snapshot.GetSampleCount())
+}
+
+func BenchmarkHistogramObserve(b *testing.B) {
+	srv := zpm.NewServer()
+	hist := srv.Histogram("bench_observe").Buckets(zpm.ExponentialBuckets(0.001, 1.5, 30)...).Bind()
+	b.RunParallel(func(pb *testing.PB) {
+		for v := 0.0; pb.Next(); v++ {
+			hist.Observe(float64(int(v) % 100))
+		}
+	})
+}
+
+func BenchmarkHistogramObserveSmall(b *testing.B) {
+	srv := zpm.NewServer()
+	hist := srv.Histogram("bench_observe_small").Buckets(zpm.ExponentialBuckets(0.001, 1.5, 30)...).Bind()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			hist.Observe(0.0005)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package zpm
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// shard is one half of a hotCold double buffer
+type shard[S any] interface {
+	// completed counts the observations fully written to the shard
+	completed() uint64
+	// merge adds cold into the shard, then zeroes cold
+	merge(cold S)
+}
+
+// hotCold double-buffers the counts of a series, so a snapshot reads
+// count, sum and buckets from the same moment without blocking writers.
+// Writers update the hot shard lock-free. A snapshot flips the shards, waits
+// for the writes still going to the now cold one, reads it and merges it
+// into the new hot one, so the hot shard always holds all observations.
+type hotCold[S shard[S]] struct {
+	countAndHotIdx atomic.Uint64 // highest bit: hot shard index, the rest: observations started
+	mu             sync.Mutex    // serializes snapshots
+	shards         [2]S
+}
+
+const hotIdxBit = 1 << 63
+
+// hot starts an observation, returning the shard to write it to.
+// The observation must be completed by the shard itself, after all its writes.
+func (h *hotCold[S]) hot() S {
+	n := h.countAndHotIdx.Add(1)
+	return h.shards[n>>63]
+}
+
+// read calls fn with the shard holding a consistent snapshot of all observations.
+func (h *hotCold[S]) read(fn func(cold S)) {
+	h.flip(nil, func(hot, cold S) {
+		fn(cold)
+		hot.merge(cold)
+	})
+}
+
+// flip swaps the shards under the snapshot lock. prepare, if set, sees the
+// current hot shard and the next one before writers do, and may cancel the flip.
+// fn runs once the now cold shard is quiescent, and must leave all observations
+// in the hot shard, zeroing the cold one.
+func (h *hotCold[S]) flip(prepare func(hot, next S) bool, fn func(hot, cold S)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if prepare != nil {
+		n := h.countAndHotIdx.Load()
+		if !prepare(h.shards[n>>63], h.shards[(^n)>>63]) {
+			return
+		}
+	}
+	n := h.countAndHotIdx.Add(hotIdxBit)
+	count := n &^ hotIdxBit
+	hot, cold := h.shards[n>>63], h.shards[(^n)>>63]
+	for cold.completed() < count {
+		runtime.Gosched()
+	}
+	fn(hot, cold)
+}
@@ -0,0 +1,140 @@
+package zpm
+
+import (
+	"hash/maphash"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// OverflowLabelValue replaces every label value of the series
+	// new label combinations are folded into past the cardinality limits
+	OverflowLabelValue = "__overflow__"
+
+	overflowFamilyName = "zpm_series_overflow_total"
+	overflowFamilyHelp = "Label combinations folded into the overflow series by cardinality limits."
+
+	// maxRejectedKeys caps the combinations a family remembers to count each once,
+	// past it they are forgotten and counted again when seen anew
+	maxRejectedKeys = 1 << 14
+)
+
+var rejectedSeed = maphash.MakeSeed()
+
+// admit reserves room for a new series of the family, under the storage lock.
+func (s *storage) admit(fam *family) bool {
+	if fam.maxSeries > 0 && fam.size() >= fam.maxSeries {
+		return false
+	}
+	return s.srv.reserveSeries()
+}
+
+// overflowed returns the family overflow series while the family is still full.
+func (s *storage) overflowed(name string) *state {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fam := s.families[name]
+	if fam == nil || fam.overflow == nil || !s.full(fam) {
+		return nil
+	}
+	return fam.overflow
+}
+
+func (s *storage) full(fam *family) bool {
+	if fam.maxSeries > 0 && fam.size() >= fam.maxSeries {
+		return true
+	}
+	maxSeries := s.srv.cfg.MaxSeries
+	return maxSeries > 0 && s.srv.series.Load() >= int64(maxSeries)
+}
+
+// hasRoom tells whether the family admits new series again
+func (s *storage) hasRoom(fam *family) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.full(fam)
+}
+
+// reject counts the series key folded into the overflow series, once per combination
+func (f *family) reject(key string) {
+	hash := maphash.String(rejectedSeed, key)
+	f.rejectedMu.Lock()
+	defer f.rejectedMu.Unlock()
+	if _, ok := f.rejectedKeys[hash]; ok {
+		return
+	}
+	if f.rejectedKeys == nil || len(f.rejectedKeys) >= maxRejectedKeys {
+		f.rejectedKeys = make(map[uint64]struct{})
+	}
+	f.rejectedKeys[hash] = struct{}{}
+	f.rejected.Add(1)
+}
+
+// size counts the family series, not counting the overflow one
+func (f *family) size() int {
+	if f.overflow != nil {
+		return len(f.series) - 1
+	}
+	return len(f.series)
+}
+
+// reserveSeries counts a new series against the server MaxSeries
+func (s *Server) reserveSeries() bool {
+	maxSeries := int64(s.cfg.MaxSeries)
+	for {
+		n := s.series.Load()
+		if maxSeries > 0 && n >= maxSeries {
+			return false
+		}
+		if s.series.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func makeOverflowLabels(labels []*dto.LabelPair) []*dto.LabelPair {
+	value := OverflowLabelValue
+	res := make([]*dto.LabelPair, len(labels))
+	for i, lbl := range labels {
+		res[i] = &dto.LabelPair{
+			Name:  lbl.Name,
+			Value: &value,
+		}
+	}
+	return res
+}
+
+// overflowFamily reports the label combinations each family folded into its overflow series,
+// or nil when no limit was ever hit.
+func (s *Server) overflowFamily() *dto.MetricFamily {
+	var metrics []*dto.Metric
+	for _, st := range s.storages() {
+		st.mu.RLock()
+		for _, name := range st.names {
+			rejected := st.families[name].rejected.Load()
+			if rejected == 0 {
+				continue
+			}
+			metrics = append(metrics, &dto.Metric{
+				Label: NewLabelPairs("family", name),
+				Counter: &dto.Counter{
+					Value: ptr(float64(rejected)),
+				},
+			})
+		}
+		st.mu.RUnlock()
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return &dto.MetricFamily{
+		Name:   ptr(overflowFamilyName),
+		Help:   ptr(overflowFamilyHelp),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: metrics,
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
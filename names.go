@@ -0,0 +1,171 @@
+package zpm
+
+import (
+	"fmt"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// NameValidation tells how names breaking the Prometheus data model are treated
+type NameValidation string
+
+const (
+	// NameValidationStrict reports invalid names through the ErrorPolicy,
+	// kept samples are sanitized
+	NameValidationStrict NameValidation = "strict"
+	// NameValidationLenient silently sanitizes invalid names
+	NameValidationLenient NameValidation = "lenient"
+)
+
+const (
+	reservedLabelPrefix = "__"
+	exportedLabelPrefix = "exported_"
+)
+
+// maxInvalidNames caps the invalid series keys a storage caches the names check outcome of
+const maxInvalidNames = 1024
+
+// checkedNames is the names check outcome of an invalid series key
+type checkedNames struct {
+	name   string
+	labels []*dto.LabelPair
+	drop   bool
+}
+
+// checkNames validates the family and label names of a call whose series key
+// is not registered, labels being sorted by name. Invalid names are sanitized,
+// unless strict validation drops the sample, which is told by the returned false.
+// The outcome of an invalid key is cached, so its call site is validated and reported once.
+func (s *storage) checkNames(key string, d *desc, labels []*dto.LabelPair, metricType dto.MetricType) (*desc, []*dto.LabelPair, bool) {
+	if cached, ok := s.invalidNames.Load(key); ok {
+		return cached.(*checkedNames).apply(d, labels)
+	}
+	err := validateNames(d.name, labels, metricType)
+	if err == nil {
+		return d, labels, true
+	}
+	checked := &checkedNames{
+		drop: s.srv.cfg.NameValidation != NameValidationLenient && s.srv.handleErrorOnce(err),
+	}
+	if !checked.drop {
+		checked.name = sanitizeName(d.name, true)
+		checked.labels = sanitizeLabels(labels, metricType)
+	}
+	if s.invalidCount.Load() < maxInvalidNames {
+		if _, loaded := s.invalidNames.LoadOrStore(key, checked); !loaded {
+			s.invalidCount.Add(1)
+		}
+	}
+	return checked.apply(d, labels)
+}
+
+func (c *checkedNames) apply(d *desc, labels []*dto.LabelPair) (*desc, []*dto.LabelPair, bool) {
+	if c.drop {
+		return d, labels, false
+	}
+	sanitized := *d
+	sanitized.name = c.name
+	return &sanitized, c.labels, true
+}
+
+func validateNames(name string, labels []*dto.LabelPair, metricType dto.MetricType) error {
+	if !validName(name, true) {
+		return fmt.Errorf("%w: metric name %q", ErrInvalidName, name)
+	}
+	for i, lbl := range labels {
+		labelName := lbl.GetName()
+		if !validName(labelName, false) {
+			return fmt.Errorf("%w: %q label name %q", ErrInvalidName, name, labelName)
+		}
+		if reservedLabel(labelName, metricType) {
+			return fmt.Errorf("%w: %q label name %q is reserved", ErrInvalidName, name, labelName)
+		}
+		if i > 0 && labels[i-1].GetName() == labelName {
+			return fmt.Errorf("%w: %q duplicate label name %q", ErrInvalidName, name, labelName)
+		}
+	}
+	return nil
+}
+
+// validName matches [a-zA-Z_:][a-zA-Z0-9_:]* for metric names
+// and [a-zA-Z_][a-zA-Z0-9_]* for label names
+func validName(name string, metric bool) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !validNameByte(name[i], i == 0, metric) {
+			return false
+		}
+	}
+	return true
+}
+
+func validNameByte(b byte, first, metric bool) bool {
+	return b >= 'a' && b <= 'z' ||
+		b >= 'A' && b <= 'Z' ||
+		b == '_' ||
+		b == ':' && metric ||
+		b >= '0' && b <= '9' && !first
+}
+
+// reservedLabel tells label names Prometheus or the exposition format use itself
+func reservedLabel(name string, metricType dto.MetricType) bool {
+	switch {
+	case strings.HasPrefix(name, reservedLabelPrefix):
+		return true
+	case name == "le":
+		return metricType == dto.MetricType_HISTOGRAM || metricType == dto.MetricType_GAUGE_HISTOGRAM
+	case name == "quantile":
+		return metricType == dto.MetricType_SUMMARY
+	}
+	return false
+}
+
+// sanitizeName replaces invalid characters with '_',
+// prefixing names that start with a digit
+func sanitizeName(name string, metric bool) string {
+	if name == "" {
+		return "_"
+	}
+	var sb strings.Builder
+	sb.Grow(len(name) + 1)
+	if name[0] >= '0' && name[0] <= '9' {
+		sb.WriteByte('_')
+	}
+	for i := 0; i < len(name); i++ {
+		if validNameByte(name[i], false, metric) {
+			sb.WriteByte(name[i])
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// sanitizeLabels returns sanitized labels sorted by name. Reserved names get
+// the "exported_" prefix, as Prometheus does on conflicts, and of duplicate
+// names the last one wins.
+func sanitizeLabels(labels []*dto.LabelPair, metricType dto.MetricType) []*dto.LabelPair {
+	res := make([]*dto.LabelPair, 0, len(labels))
+	for _, lbl := range labels {
+		name := sanitizeName(lbl.GetName(), false)
+		if reservedLabel(name, metricType) {
+			name = exportedLabelPrefix + name
+		}
+		res = append(res, &dto.LabelPair{
+			Name:  &name,
+			Value: lbl.Value,
+		})
+	}
+	res = sortLabels(res)
+	deduped := res[:0]
+	for i, lbl := range res {
+		if i+1 < len(res) && res[i+1].GetName() == lbl.GetName() {
+			continue
+		}
+		deduped = append(deduped, lbl)
+	}
+	return deduped
+}
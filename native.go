@@ -0,0 +1,213 @@
+package zpm
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// NativeMinSchema is the coarsest native histogram resolution, growth factor 65536
+	NativeMinSchema = -4
+	// NativeMaxSchema is the finest native histogram resolution, growth factor ~1.0027
+	NativeMaxSchema = 8
+	// NativeZeroThreshold is the default width of the native histogram zero bucket
+	NativeZeroThreshold = 2.938735877055719e-39 // 2^-128
+	// NativeMaxBuckets is the default populated buckets limit before the resolution is halved
+	NativeMaxBuckets = 160
+)
+
+// nativeBounds holds per positive schema the upper bounds of the buckets
+// within [0.5, 1), the mantissa range of math.Frexp.
+var nativeBounds = func() (res [NativeMaxSchema + 1][]float64) {
+	for schema := 1; schema <= NativeMaxSchema; schema++ {
+		n := 1 << schema
+		res[schema] = make([]float64, n)
+		for i := range n {
+			res[schema][i] = math.Exp2(float64(i)/float64(n) - 1)
+		}
+	}
+	return res
+}()
+
+// nativeConfig is the native histogram mode of a histogram builder
+type nativeConfig struct {
+	enabled       bool
+	schema        int32
+	zeroThreshold *float64
+	maxBuckets    uint32
+}
+
+// nativeSchema picks the finest schema whose bucket growth does not exceed factor
+func nativeSchema(factor float64) int32 {
+	if !(factor > 1) {
+		return NativeMaxSchema
+	}
+	floor := math.Floor(math.Log2(math.Log2(factor)))
+	return clampSchema(-int32(max(min(floor, 8), -8)))
+}
+
+func clampSchema(schema int32) int32 {
+	return max(min(schema, NativeMaxSchema), NativeMinSchema)
+}
+
+// nativeKey returns the index of the bucket holding the absolute value of v.
+// Bucket i spans (base^(i-1), base^i], with base = 2^(2^-schema).
+func nativeKey(v float64, schema int32) int {
+	v = math.Abs(v)
+	if math.IsInf(v, 0) {
+		return nativeKey(math.MaxFloat64, schema) + 1
+	}
+	frac, exp := math.Frexp(v)
+	if schema > 0 {
+		bounds := nativeBounds[schema]
+		return sort.SearchFloat64s(bounds, frac) + (exp-1)*len(bounds)
+	}
+	key := exp
+	if frac == 0.5 {
+		key--
+	}
+	offset := (1 << -schema) - 1
+	return (key + offset) >> -schema
+}
+
+// nativeShard keeps the sparse buckets of a histogram shard
+type nativeShard struct {
+	schema    atomic.Int32 // changed only while the shard is cold
+	zeroCount atomic.Uint64
+	positive  sync.Map // bucket key int -> *atomic.Uint64
+	negative  sync.Map
+	populated atomic.Uint32
+}
+
+func newNativeShard(schema int32) *nativeShard {
+	res := &nativeShard{}
+	res.schema.Store(schema)
+	return res
+}
+
+// observe counts v, reporting whether it populated a new bucket
+func (s *nativeShard) observe(v, zeroThreshold float64) bool {
+	switch {
+	case math.IsNaN(v):
+		return false
+	case math.Abs(v) <= zeroThreshold:
+		s.zeroCount.Add(1)
+		return false
+	case v > 0:
+		return s.add(&s.positive, nativeKey(v, s.schema.Load()), 1)
+	default:
+		return s.add(&s.negative, nativeKey(v, s.schema.Load()), 1)
+	}
+}
+
+func (s *nativeShard) add(buckets *sync.Map, key int, n uint64) bool {
+	if count, ok := buckets.Load(key); ok {
+		count.(*atomic.Uint64).Add(n)
+		return false
+	}
+	count, loaded := buckets.LoadOrStore(key, &atomic.Uint64{})
+	count.(*atomic.Uint64).Add(n)
+	if !loaded {
+		s.populated.Add(1)
+	}
+	return !loaded
+}
+
+// merge adds cold into the shard, widening cold buckets when its schema is finer,
+// then zeroes cold and aligns its schema.
+func (s *nativeShard) merge(cold *nativeShard) {
+	schema := s.schema.Load()
+	shift := cold.schema.Load() - schema
+	move := func(dst, src *sync.Map) {
+		src.Range(func(key, count any) bool {
+			k := key.(int)
+			for range shift {
+				k = (k + 1) >> 1
+			}
+			if n := count.(*atomic.Uint64).Load(); n > 0 {
+				s.add(dst, k, n)
+			}
+			return true
+		})
+		src.Clear()
+	}
+	move(&s.positive, &cold.positive)
+	move(&s.negative, &cold.negative)
+	s.zeroCount.Add(cold.zeroCount.Swap(0))
+	cold.populated.Store(0)
+	cold.schema.Store(schema)
+}
+
+// fill writes the sparse fields of res, the shard must be cold
+func (s *nativeShard) fill(res *dto.Histogram, zeroThreshold float64) {
+	res.Schema = ptr(s.schema.Load())
+	res.ZeroThreshold = ptr(zeroThreshold)
+	res.ZeroCount = ptr(s.zeroCount.Load())
+	res.PositiveSpan, res.PositiveDelta = nativeSpans(&s.positive)
+	res.NegativeSpan, res.NegativeDelta = nativeSpans(&s.negative)
+	if len(res.PositiveSpan) == 0 && len(res.NegativeSpan) == 0 {
+		// a no-op span tells a native histogram without observations from a classic one
+		res.PositiveSpan = []*dto.BucketSpan{{
+			Offset: ptr[int32](0),
+			Length: ptr[uint32](0),
+		}}
+	}
+}
+
+// nativeSpans encodes the buckets as spans of consecutive keys
+// and counts delta-encoded against the previous bucket.
+func nativeSpans(buckets *sync.Map) ([]*dto.BucketSpan, []int64) {
+	var keys []int
+	counts := map[int]uint64{}
+	buckets.Range(func(key, count any) bool {
+		k := key.(int)
+		keys = append(keys, k)
+		counts[k] = count.(*atomic.Uint64).Load()
+		return true
+	})
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	slices.Sort(keys)
+	var (
+		spans  []*dto.BucketSpan
+		deltas = make([]int64, len(keys))
+		prev   int64
+	)
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1]+1 {
+			offset := k
+			if i > 0 {
+				offset = k - keys[i-1] - 1
+			}
+			spans = append(spans, &dto.BucketSpan{
+				Offset: ptr(int32(offset)),
+				Length: ptr[uint32](0),
+			})
+		}
+		*spans[len(spans)-1].Length++
+		count := int64(counts[k])
+		deltas[i] = count - prev
+		prev = count
+	}
+	return spans, deltas
+}
+
+// limitBuckets halves the resolution while the hot shard holds more than maxBuckets buckets
+func (h *histogramCounts) limitBuckets() {
+	h.flip(func(hot, next *histogramShard) bool {
+		schema := hot.native.schema.Load()
+		if hot.native.populated.Load() <= h.maxBuckets || schema <= NativeMinSchema {
+			return false
+		}
+		next.native.schema.Store(schema - 1)
+		return true
+	}, func(hot, cold *histogramShard) {
+		hot.merge(cold)
+	})
+}
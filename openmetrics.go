@@ -0,0 +1,83 @@
+package zpm
+
+import (
+	"fmt"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const counterSuffix = "_total"
+
+var (
+	FmtOpenMetrics = expfmt.NewFormat(expfmt.TypeOpenMetrics)
+)
+
+// openMetricsFamilies adapts gathered families to a valid OpenMetrics document.
+// Counters get the mandatory _total suffix, which the encoder would otherwise
+// export as unknown type, and units are sanitized into metric name suffixes.
+// Adapted families are copies, those of collectors and gatherers may be reused by their owner.
+func (s *Server) openMetricsFamilies(families []*dto.MetricFamily) ([]*dto.MetricFamily, error) {
+	renamed := false
+	for i, fam := range families {
+		counter := fam.GetType() == dto.MetricType_COUNTER && !strings.HasSuffix(fam.GetName(), counterSuffix)
+		if !counter && fam.Unit == nil {
+			continue
+		}
+		fam = &dto.MetricFamily{
+			Name:   fam.Name,
+			Help:   fam.Help,
+			Type:   fam.Type,
+			Unit:   fam.Unit,
+			Metric: fam.Metric,
+		}
+		if counter {
+			fam.Name = ptr(fam.GetName() + counterSuffix)
+			renamed = true
+		}
+		if fam.Unit != nil {
+			fam.Unit = ptr(sanitizeName(fam.GetUnit(), false))
+		}
+		families[i] = fam
+	}
+	if err := checkOpenMetricsDuplicates(families); err != nil {
+		return nil, err
+	}
+	if renamed && s.cfg.SortNames {
+		sortFamilies(families)
+	}
+	return families, nil
+}
+
+// checkOpenMetricsDuplicates reports as ErrDuplicateFamily two families sharing
+// their OpenMetrics name, which counters declare without the _total suffix,
+// or sharing a sample name, e.g. a counter renamed into an existing family.
+func checkOpenMetricsDuplicates(families []*dto.MetricFamily) error {
+	if err := checkDuplicates(families); err != nil {
+		return err
+	}
+	seen := make(map[string]dto.MetricType, len(families))
+	for _, fam := range families {
+		name := fam.GetName()
+		if fam.GetType() == dto.MetricType_COUNTER {
+			name = strings.TrimSuffix(name, counterSuffix)
+		}
+		if prev, ok := seen[name]; ok {
+			return fmt.Errorf("%w: %q is exported as %s and %s", ErrDuplicateFamily, name, prev, fam.GetType())
+		}
+		seen[name] = fam.GetType()
+	}
+	return nil
+}
+
+func (s *Server) openMetricsOptions() []expfmt.EncoderOption {
+	var res []expfmt.EncoderOption
+	if s.cfg.CreatedLines {
+		res = append(res, expfmt.WithCreatedLines())
+	}
+	if s.cfg.WithUnit {
+		res = append(res, expfmt.WithUnit())
+	}
+	return res
+}
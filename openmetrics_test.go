@@ -0,0 +1,67 @@
+package zpm_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestOpenMetricsExport(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	srv := zpm.NewServer().
+		SortNames(true).
+		OptClock(func() time.Time { return created }).
+		OptCreatedLines(true).
+		OptWithUnit(true)
+	srv.Counter("requests").Help("requests served").Inc(3)
+	srv.Counter("sent_bytes_total").Unit("bytes").Inc(10)
+	srv.Histogram("latency").Unit("seconds").Buckets(0.1, 1).Observe(0.5)
+	srv.Gauge("temperature").Set(21)
+
+	res, err := srv.String(zpm.FmtOpenMetrics)
+	require.NoError(t, err)
+	expected := []string{
+		"# TYPE latency_seconds histogram",
+		"# UNIT latency_seconds seconds",
+		"latency_seconds_bucket{le=\"1.0\"} 1",
+		"latency_seconds_created 1.7e+09",
+		"# HELP requests requests served",
+		"# TYPE requests counter",
+		"requests_total 3.0",
+		"requests_created 1.7e+09",
+		"# TYPE sent_bytes counter",
+		"# UNIT sent_bytes bytes",
+		"sent_bytes_total 10.0",
+		"# TYPE temperature gauge",
+		"temperature 21.0",
+	}
+	for _, line := range expected {
+		assert.Contains(t, res, line+"\n")
+	}
+	assert.True(t, strings.HasSuffix(res, "# EOF\n"))
+
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "requests 3\n", "text format keeps names and samples carry no timestamp")
+}
+
+func TestOpenMetricsDuplicates(t *testing.T) {
+	srv := zpm.NewServer()
+	srv.Counter("jobs_total").Inc(1)
+	srv.Gauge("jobs").Set(1)
+	_, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err, "distinct names in the text format")
+	_, err = srv.String(zpm.FmtOpenMetrics)
+	assert.ErrorIs(t, err, zpm.ErrDuplicateFamily, "both declare the jobs family")
+
+	srv = zpm.NewServer()
+	srv.Counter("tasks").Inc(1)
+	srv.Gauge("tasks_total").Set(1)
+	_, err = srv.String(zpm.FmtOpenMetrics)
+	assert.ErrorIs(t, err, zpm.ErrDuplicateFamily, "the counter is renamed into the gauge")
+}
@@ -0,0 +1,246 @@
+package zpm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ProcessCollectorName is the collector name OptProcessMetrics registers under
+const ProcessCollectorName = "process"
+
+// DefaultProcfs is the procfs root read by OptProcessMetrics without a path
+const DefaultProcfs = "/proc"
+
+// procTicks is the USER_HZ of /proc/self/stat times, 100 on every Linux architecture
+const procTicks = 100
+
+// processCollector reads the process metrics of procfs/self
+type processCollector struct {
+	procfs string
+}
+
+// OptProcessMetrics registers the process collector under ProcessCollectorName, replacing the previous one.
+// procfs is the procfs root, empty meaning DefaultProcfs. Without procfs/self, e.g. not on Linux,
+// the error is reported and nothing is registered.
+func (s *Server) OptProcessMetrics(procfs string) *Server {
+	if procfs == "" {
+		procfs = DefaultProcfs
+	}
+	s.UnregisterCollector(ProcessCollectorName)
+	if _, err := os.Stat(filepath.Join(procfs, "self")); err != nil {
+		s.reportError(fmt.Errorf("collector %q: %w", ProcessCollectorName, err))
+		return s
+	}
+	_ = s.RegisterGatherer(ProcessCollectorName, NewProcessCollector(procfs))
+	return s
+}
+
+// NewProcessCollector exports the standard process metrics read from procfs/self:
+// CPU seconds, resident and virtual memory, open and max file descriptors,
+// start time, threads and context switches.
+// It is a Gatherer, so a file failing to read is reported while the other families are exported.
+func NewProcessCollector(procfs string) Gatherer {
+	return &processCollector{procfs: procfs}
+}
+
+func (c *processCollector) Gather() ([]*dto.MetricFamily, error) {
+	var res []*dto.MetricFamily
+	var errs []error
+	for _, read := range []func() ([]*dto.MetricFamily, error){
+		c.stat, c.status, c.limits, c.fds,
+	} {
+		families, err := read()
+		res = append(res, families...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// stat reads CPU seconds and start time from self/stat, and the boot time from stat
+func (c *processCollector) stat() ([]*dto.MetricFamily, error) {
+	data, err := os.ReadFile(filepath.Join(c.procfs, "self", "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// the command name may hold spaces and parentheses, fields follow its last ')'
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("%s/self/stat: no command name", c.procfs)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("%s/self/stat: %d fields", c.procfs, len(fields))
+	}
+	// fields[0] is the third field of proc(5), state
+	utime, err1 := strconv.ParseFloat(fields[11], 64)
+	stime, err2 := strconv.ParseFloat(fields[12], 64)
+	start, err3 := strconv.ParseFloat(fields[19], 64)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("%s/self/stat: %w", c.procfs, err)
+	}
+	res := []*dto.MetricFamily{
+		processFamily("process_cpu_seconds_total", "Total user and system CPU time spent in seconds.",
+			runtimeScalar(dto.MetricType_COUNTER, (utime+stime)/procTicks)),
+	}
+	bootTime, err := c.bootTime()
+	if err != nil {
+		return res, err
+	}
+	return append(res, processFamily("process_start_time_seconds", "Start time of the process since unix epoch in seconds.",
+		runtimeScalar(dto.MetricType_GAUGE, bootTime+start/procTicks))), nil
+}
+
+func (c *processCollector) bootTime() (float64, error) {
+	var res float64
+	err := c.scan("stat", func(_ string, fields []string) error {
+		if fields[0] == "btime" && len(fields) > 1 {
+			var err error
+			res, err = strconv.ParseFloat(fields[1], 64)
+			return err
+		}
+		return nil
+	})
+	if err == nil && res == 0 {
+		err = fmt.Errorf("%s/stat: no btime", c.procfs)
+	}
+	return res, err
+}
+
+// status reads memory, threads and context switches from self/status
+func (c *processCollector) status() ([]*dto.MetricFamily, error) {
+	values := map[string]float64{}
+	err := c.scan("self/status", func(_ string, fields []string) error {
+		key := fields[0]
+		switch key {
+		case "VmRSS:", "VmSize:", "Threads:", "voluntary_ctxt_switches:", "nonvoluntary_ctxt_switches:":
+		default:
+			return nil
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s no value", key)
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= 1024
+		}
+		values[key] = value
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []*dto.MetricFamily
+	if v, ok := values["VmRSS:"]; ok {
+		res = append(res, processFamily("process_resident_memory_bytes", "Resident memory size in bytes.",
+			runtimeScalar(dto.MetricType_GAUGE, v)))
+	}
+	if v, ok := values["VmSize:"]; ok {
+		res = append(res, processFamily("process_virtual_memory_bytes", "Virtual memory size in bytes.",
+			runtimeScalar(dto.MetricType_GAUGE, v)))
+	}
+	if v, ok := values["Threads:"]; ok {
+		res = append(res, processFamily("process_threads", "Number of OS threads in the process.",
+			runtimeScalar(dto.MetricType_GAUGE, v)))
+	}
+	var switches []*dto.Metric
+	for _, kind := range []string{"voluntary", "nonvoluntary"} {
+		if v, ok := values[kind+"_ctxt_switches:"]; ok {
+			metric := runtimeScalar(dto.MetricType_COUNTER, v)
+			metric.Label = []*dto.LabelPair{{Name: ptr("type"), Value: ptr(kind)}}
+			switches = append(switches, metric)
+		}
+	}
+	if len(switches) > 0 {
+		res = append(res, processFamily("process_context_switches_total", "Number of context switches by type.",
+			switches...))
+	}
+	return res, nil
+}
+
+// limits reads the soft limits of open files and address space from self/limits,
+// unlimited being exported as +Inf
+func (c *processCollector) limits() ([]*dto.MetricFamily, error) {
+	var res []*dto.MetricFamily
+	err := c.scan("self/limits", func(line string, _ []string) error {
+		var name, help string
+		switch {
+		case strings.HasPrefix(line, "Max open files"):
+			name, help = "process_max_fds", "Maximum number of open file descriptors."
+			line = line[len("Max open files"):]
+		case strings.HasPrefix(line, "Max address space"):
+			name, help = "process_virtual_memory_max_bytes", "Maximum amount of virtual memory available in bytes."
+			line = line[len("Max address space"):]
+		default:
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return fmt.Errorf("%s no soft limit", name)
+		}
+		value := math.Inf(1)
+		if fields[0] != "unlimited" {
+			var err error
+			if value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+				return err
+			}
+		}
+		res = append(res, processFamily(name, help, runtimeScalar(dto.MetricType_GAUGE, value)))
+		return nil
+	})
+	return res, err
+}
+
+// fds counts the entries of self/fd
+func (c *processCollector) fds() ([]*dto.MetricFamily, error) {
+	entries, err := os.ReadDir(filepath.Join(c.procfs, "self", "fd"))
+	if err != nil {
+		return nil, err
+	}
+	return []*dto.MetricFamily{
+		processFamily("process_open_fds", "Number of open file descriptors.",
+			runtimeScalar(dto.MetricType_GAUGE, float64(len(entries)))),
+	}, nil
+}
+
+// scan calls fn with the fields of every line of a procfs file
+func (c *processCollector) scan(name string, fn func(line string, fields []string) error) error {
+	f, err := os.Open(filepath.Join(c.procfs, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			if err := fn(scanner.Text(), fields); err != nil {
+				return fmt.Errorf("%s/%s: %w", c.procfs, name, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// processFamily makes a counter family of counter metrics, a gauge family otherwise
+func processFamily(name, help string, metrics ...*dto.Metric) *dto.MetricFamily {
+	metricType := dto.MetricType_GAUGE
+	if metrics[0].Counter != nil {
+		metricType = dto.MetricType_COUNTER
+	}
+	return &dto.MetricFamily{
+		Name:   ptr(name),
+		Help:   ptr(help),
+		Type:   metricType.Enum(),
+		Metric: metrics,
+	}
+}
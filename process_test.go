@@ -0,0 +1,66 @@
+package zpm_test
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestProcessCollector(t *testing.T) {
+	srv := zpm.NewServer().SortNames(true).OptProcessMetrics("testdata/proc")
+	families := gatherByName(t, srv)
+	gauges := map[string]float64{
+		"process_start_time_seconds":       1700000050,
+		"process_resident_memory_bytes":    8192 * 1024,
+		"process_virtual_memory_bytes":     1048576 * 1024,
+		"process_virtual_memory_max_bytes": math.Inf(1),
+		"process_open_fds":                 4,
+		"process_max_fds":                  1024,
+		"process_threads":                  7,
+	}
+	for name, want := range gauges {
+		require.Contains(t, families, name)
+		assert.Equal(t, want, families[name].Metric[0].GetGauge().GetValue(), name)
+	}
+	assert.Equal(t, 3.8, families["process_cpu_seconds_total"].Metric[0].GetCounter().GetValue())
+
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "process_context_switches_total{type=\"voluntary\"} 150\n")
+	assert.Contains(t, res, "process_context_switches_total{type=\"nonvoluntary\"} 12\n")
+}
+
+func TestProcessCollectorPartial(t *testing.T) {
+	procfs := t.TempDir()
+	require.NoError(t, os.CopyFS(procfs, os.DirFS("testdata/proc")))
+	require.NoError(t, os.Remove(procfs+"/self/limits"))
+
+	families, err := zpm.NewProcessCollector(procfs).Gather()
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	assert.Len(t, families, 7, "families of readable files")
+
+	var reported []error
+	srv := zpm.NewServer().
+		OptErrorPolicy(zpm.ErrorPolicyPanic).
+		OptErrorHandler(func(err error) { reported = append(reported, err) })
+	require.NotPanics(t, func() { srv.OptProcessMetrics(procfs + "/missing") })
+	require.Len(t, reported, 1)
+	assert.True(t, errors.Is(reported[0], fs.ErrNotExist))
+	assert.Empty(t, gatherByName(t, srv), "nothing registered without procfs")
+}
+
+func TestProcessCollectorSelf(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no procfs")
+	}
+	families, err := zpm.NewProcessCollector(zpm.DefaultProcfs).Gather()
+	require.NoError(t, err)
+	assert.Len(t, families, 9)
+}
@@ -0,0 +1,184 @@
+package zpm
+
+import (
+	"math"
+	"runtime/metrics"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// RuntimeCollectorName is the collector name OptRuntimeMetrics registers under
+const RuntimeCollectorName = "go_runtime"
+
+// DefaultRuntimeMetrics is the runtime/metrics allowlist of OptRuntimeMetrics without arguments:
+// GC pauses, scheduler latency, goroutines, GOMAXPROCS, the memory limit and heap sizes.
+var DefaultRuntimeMetrics = []string{
+	"/sched/pauses/total/gc:seconds",
+	"/sched/latencies:seconds",
+	"/sched/goroutines:goroutines",
+	"/sched/gomaxprocs:threads",
+	"/gc/gomemlimit:bytes",
+	"/gc/heap/goal:bytes",
+	"/gc/heap/allocs:bytes",
+	"/gc/heap/objects:objects",
+	"/gc/cycles/total:gc-cycles",
+	"/memory/classes/heap/",
+	"/memory/classes/total:bytes",
+}
+
+// runtimeBucketFactor is the minimal ratio between the bounds of exported runtime histograms.
+// runtime/metrics histograms have hundreds of buckets, coarsened to about 40 for seconds.
+const runtimeBucketFactor = 2
+
+// runtimeDesc is a runtime/metrics sample exported as a family
+type runtimeDesc struct {
+	name       string
+	help       string
+	metricType dto.MetricType
+}
+
+// runtimeCollector exports runtime/metrics samples
+type runtimeCollector struct {
+	mu      sync.Mutex // guards samples, reused across reads
+	descs   []runtimeDesc
+	samples []metrics.Sample
+}
+
+// OptRuntimeMetrics registers the Go runtime collector under RuntimeCollectorName,
+// replacing the previous one. See NewRuntimeCollector for the allowlist, empty meaning DefaultRuntimeMetrics.
+func (s *Server) OptRuntimeMetrics(allow ...string) *Server {
+	if len(allow) == 0 {
+		allow = DefaultRuntimeMetrics
+	}
+	s.UnregisterCollector(RuntimeCollectorName)
+	_ = s.RegisterCollector(RuntimeCollectorName, NewRuntimeCollector(allow...))
+	return s
+}
+
+// NewRuntimeCollector exports the runtime/metrics samples named in allow, e.g. "/sched/goroutines:goroutines",
+// an entry ending with a slash allowing every sample under it, e.g. "/gc/", and a single slash allowing all.
+// Names unknown to the running Go version are skipped.
+// Families are named after the samples as client_golang does: /sched/goroutines:goroutines
+// is exported as go_sched_goroutines_goroutines, cumulative samples get the _total suffix.
+func NewRuntimeCollector(allow ...string) Collector {
+	res := &runtimeCollector{}
+	for _, d := range metrics.All() {
+		if !runtimeAllowed(d.Name, allow) {
+			continue
+		}
+		var metricType dto.MetricType
+		switch d.Kind {
+		case metrics.KindUint64, metrics.KindFloat64:
+			metricType = dto.MetricType_GAUGE
+			if d.Cumulative {
+				metricType = dto.MetricType_COUNTER
+			}
+		case metrics.KindFloat64Histogram:
+			metricType = dto.MetricType_HISTOGRAM
+		default:
+			continue
+		}
+		res.descs = append(res.descs, runtimeDesc{
+			name:       runtimeMetricName(d.Name, metricType == dto.MetricType_COUNTER),
+			help:       d.Description,
+			metricType: metricType,
+		})
+		res.samples = append(res.samples, metrics.Sample{Name: d.Name})
+	}
+	return res
+}
+
+func runtimeAllowed(name string, allow []string) bool {
+	for _, a := range allow {
+		if a == name || strings.HasSuffix(a, "/") && strings.HasPrefix(name, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// runtimeMetricName maps /gc/heap/goal:bytes to go_gc_heap_goal_bytes
+func runtimeMetricName(key string, counter bool) string {
+	path, unit, _ := strings.Cut(key, ":")
+	res := sanitizeName("go"+strings.ReplaceAll(path, "/", "_")+"_"+unit, false)
+	if counter {
+		res += counterSuffix
+	}
+	return res
+}
+
+func (c *runtimeCollector) Collect(emit func(*dto.MetricFamily)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metrics.Read(c.samples)
+	for i, sample := range c.samples {
+		d := c.descs[i]
+		var metric *dto.Metric
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			metric = runtimeScalar(d.metricType, float64(sample.Value.Uint64()))
+		case metrics.KindFloat64:
+			metric = runtimeScalar(d.metricType, sample.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			metric = &dto.Metric{Histogram: runtimeHistogram(sample.Value.Float64Histogram())}
+		default:
+			continue
+		}
+		emit(&dto.MetricFamily{
+			Name:   ptr(d.name),
+			Help:   ptr(d.help),
+			Type:   d.metricType.Enum(),
+			Metric: []*dto.Metric{metric},
+		})
+	}
+}
+
+func runtimeScalar(metricType dto.MetricType, value float64) *dto.Metric {
+	if metricType == dto.MetricType_COUNTER {
+		return &dto.Metric{Counter: &dto.Counter{Value: ptr(value)}}
+	}
+	return &dto.Metric{Gauge: &dto.Gauge{Value: ptr(value)}}
+}
+
+// runtimeHistogram converts a runtime/metrics histogram, keeping the bounds
+// at least runtimeBucketFactor apart. The counts stay exact, as the kept bounds
+// are a subset of the original ones. runtime/metrics gives no sum, it is estimated
+// from the bucket midpoints like client_golang does, an infinite bound
+// being replaced by the finite one of its bucket.
+func runtimeHistogram(h *metrics.Float64Histogram) *dto.Histogram {
+	res := &dto.Histogram{}
+	var count uint64
+	var sum float64
+	last := math.Inf(-1)
+	for i, n := range h.Counts {
+		count += n
+		if n > 0 {
+			sum += float64(n) * runtimeMidpoint(h.Buckets[i], h.Buckets[i+1])
+		}
+		upper := h.Buckets[i+1]
+		if math.IsInf(upper, 0) || last > 0 && upper < last*runtimeBucketFactor {
+			continue
+		}
+		res.Bucket = append(res.Bucket, &dto.Bucket{
+			UpperBound:      ptr(upper),
+			CumulativeCount: ptr(count),
+		})
+		last = upper
+	}
+	res.SampleCount = ptr(count)
+	res.SampleSum = ptr(sum)
+	return res
+}
+
+// runtimeMidpoint is the middle of a runtime/metrics bucket, or its finite bound when the other is infinite
+func runtimeMidpoint(lower, upper float64) float64 {
+	switch {
+	case math.IsInf(lower, -1):
+		return upper
+	case math.IsInf(upper, 1):
+		return lower
+	}
+	return (lower + upper) / 2
+}
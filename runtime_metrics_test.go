@@ -0,0 +1,96 @@
+package zpm_test
+
+import (
+	"runtime"
+	"runtime/metrics"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func gatherByName(t *testing.T, srv *zpm.Server) map[string]*dto.MetricFamily {
+	families, err := srv.Gather()
+	require.NoError(t, err)
+	res := make(map[string]*dto.MetricFamily, len(families))
+	for _, fam := range families {
+		res[fam.GetName()] = fam
+	}
+	return res
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	runtime.GC()
+	srv := zpm.NewServer().OptRuntimeMetrics().OptRuntimeMetrics()
+	families := gatherByName(t, srv)
+	for _, name := range []string{
+		"go_sched_goroutines_goroutines",
+		"go_sched_gomaxprocs_threads",
+		"go_gc_gomemlimit_bytes",
+		"go_gc_heap_goal_bytes",
+		"go_memory_classes_heap_objects_bytes",
+		"go_memory_classes_total_bytes",
+	} {
+		require.Contains(t, families, name)
+		assert.Equal(t, dto.MetricType_GAUGE, families[name].GetType(), name)
+		assert.NotEmpty(t, families[name].GetHelp(), name)
+	}
+	assert.Equal(t, float64(runtime.GOMAXPROCS(0)), families["go_sched_gomaxprocs_threads"].Metric[0].GetGauge().GetValue())
+
+	cycles := families["go_gc_cycles_total_gc_cycles_total"]
+	require.NotNil(t, cycles, "cumulative samples are counters with the _total suffix")
+	assert.Equal(t, dto.MetricType_COUNTER, cycles.GetType())
+	assert.GreaterOrEqual(t, cycles.Metric[0].GetCounter().GetValue(), 1.0)
+
+	for _, name := range []string{"go_sched_pauses_total_gc_seconds", "go_sched_latencies_seconds"} {
+		require.Contains(t, families, name)
+		h := families[name].Metric[0].GetHistogram()
+		require.NotNil(t, h, name)
+		assert.LessOrEqual(t, len(h.Bucket), 64, "%s buckets coarsened", name)
+		var prevBound float64
+		var prevCount uint64
+		for i, b := range h.Bucket {
+			if i > 0 {
+				assert.GreaterOrEqual(t, b.GetUpperBound(), 2*prevBound, name)
+			}
+			assert.GreaterOrEqual(t, b.GetCumulativeCount(), prevCount, name)
+			prevBound, prevCount = b.GetUpperBound(), b.GetCumulativeCount()
+		}
+		assert.GreaterOrEqual(t, h.GetSampleCount(), prevCount, name)
+		if h.GetSampleCount() > 0 {
+			assert.Positive(t, h.GetSampleSum(), "%s sum estimated from bucket midpoints", name)
+		}
+	}
+	assert.NotZero(t, families["go_sched_pauses_total_gc_seconds"].Metric[0].GetHistogram().GetSampleCount())
+
+	_, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	_, err = srv.String(zpm.FmtOpenMetrics)
+	require.NoError(t, err)
+}
+
+func TestRuntimeMetricsAllowlist(t *testing.T) {
+	srv := zpm.NewServer().OptRuntimeMetrics("/sched/goroutines:goroutines", "/cpu/classes/gc/", "/no/such:metric")
+	families := gatherByName(t, srv)
+	assert.Contains(t, families, "go_sched_goroutines_goroutines")
+	assert.Contains(t, families, "go_cpu_classes_gc_total_cpu_seconds_total")
+	for name := range families {
+		assert.Regexp(t, `^go_(sched_goroutines|cpu_classes_gc_)`, name)
+	}
+
+	all := zpm.NewServer().OptRuntimeMetrics("/")
+	families = gatherByName(t, all)
+	supported := 0
+	for _, d := range metrics.All() {
+		if d.Kind != metrics.KindBad {
+			supported++
+		}
+	}
+	assert.Len(t, families, supported, "a single slash allows every sample")
+
+	assert.True(t, all.UnregisterCollector(zpm.RuntimeCollectorName))
+	assert.Empty(t, gatherByName(t, all))
+}
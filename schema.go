@@ -0,0 +1,108 @@
+package zpm
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// desc is what a builder declares about its family.
+// Unset (nil) attributes are not checked against the family schema.
+type desc struct {
+	name      string
+	help      *string
+	unit      *string
+	buckets   []float64
+	quantiles []float64
+	ttl       time.Duration
+	maxSeries int
+
+	defaultBuckets []float64 // the effective bounds when buckets is unset
+}
+
+// schema is the family shape recorded by its first series
+type schema struct {
+	metricType dto.MetricType
+	help       *string
+	unit       *string
+	labelNames []string
+	buckets    []float64
+	quantiles  []float64
+}
+
+// family is a registered metric family along with its schema and series
+type family struct {
+	dto       *dto.MetricFamily
+	schema    schema
+	series    []*state
+	storage   *storage
+	ttl       time.Duration
+	maxSeries int
+	overflow  *state
+	rejected  atomic.Uint64
+
+	rejectedMu   sync.Mutex
+	rejectedKeys map[uint64]struct{} // hashes of the combinations counted in rejected
+}
+
+// newSchema records the effective bounds of a histogram, so the family registered
+// without Buckets is checked against the DefaultBuckets its series got.
+func newSchema(d *desc, metricType dto.MetricType, labels []*dto.LabelPair) schema {
+	buckets := d.buckets
+	if buckets == nil {
+		buckets = d.defaultBuckets
+	}
+	return schema{
+		metricType: metricType,
+		help:       d.help,
+		unit:       d.unit,
+		labelNames: labelNames(labels),
+		buckets:    buckets,
+		quantiles:  d.quantiles,
+	}
+}
+
+// check reports the first difference between the schema and a builder call
+// as ErrSchemaMismatch. Labels must be sorted by name.
+func (s *schema) check(d *desc, labels []*dto.LabelPair) error {
+	if s.help != nil && d.help != nil && *s.help != *d.help {
+		return fmt.Errorf("%w: %s %q help %q, registered %q", ErrSchemaMismatch, s.metricType, d.name, *d.help, *s.help)
+	}
+	if s.unit != nil && d.unit != nil && *s.unit != *d.unit {
+		return fmt.Errorf("%w: %s %q unit %q, registered %q", ErrSchemaMismatch, s.metricType, d.name, *d.unit, *s.unit)
+	}
+	if !sameLabelNames(s.labelNames, labels) {
+		return fmt.Errorf("%w: %s %q label names %q, registered %q", ErrSchemaMismatch, s.metricType, d.name, labelNames(labels), s.labelNames)
+	}
+	if s.buckets != nil && d.buckets != nil && !slices.Equal(s.buckets, d.buckets) {
+		return fmt.Errorf("%w: %s %q buckets %v, registered %v", ErrSchemaMismatch, s.metricType, d.name, d.buckets, s.buckets)
+	}
+	if s.quantiles != nil && d.quantiles != nil && !slices.Equal(s.quantiles, d.quantiles) {
+		return fmt.Errorf("%w: %s %q quantiles %v, registered %v", ErrSchemaMismatch, s.metricType, d.name, d.quantiles, s.quantiles)
+	}
+	return nil
+}
+
+func labelNames(labels []*dto.LabelPair) []string {
+	res := make([]string, len(labels))
+	for i, lbl := range labels {
+		res[i] = lbl.GetName()
+	}
+	return res
+}
+
+func sameLabelNames(names []string, labels []*dto.LabelPair) bool {
+	if len(names) != len(labels) {
+		return false
+	}
+	for i, lbl := range labels {
+		if names[i] != lbl.GetName() {
+			return false
+		}
+	}
+	return true
+}
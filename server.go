@@ -2,9 +2,14 @@ package zpm
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
 
@@ -13,7 +18,22 @@ var (
 )
 
 type ServerConfig struct {
-	SortNames bool `json:"sort_names"`
+	SortNames      bool           `json:"sort_names"`
+	OnError        ErrorPolicy    `json:"on_error"`
+	NameValidation NameValidation `json:"name_validation"`
+	// SeriesTTL evicts series idle for longer, unless their family sets its own TTL.
+	// It applies to series created after it is set.
+	SeriesTTL time.Duration `json:"series_ttl"`
+	// MaxSeries caps the series count of the server, MaxFamilySeries of every family
+	// not setting its own cap. New label combinations past a cap are folded
+	// into the family overflow series. Zero means unlimited.
+	MaxSeries       int `json:"max_series"`
+	MaxFamilySeries int `json:"max_family_series"`
+	// CreatedLines and WithUnit drive the matching expfmt options of OpenMetrics exports
+	CreatedLines bool `json:"created_lines"`
+	WithUnit     bool `json:"with_unit"`
+	// CallbackTimeout bounds every CounterFunc and GaugeFunc callback during an export
+	CallbackTimeout time.Duration `json:"callback_timeout"`
 }
 
 type Server struct {
@@ -22,7 +42,15 @@ type Server struct {
 	histograms *storage
 	summaries  *storage
 
-	cfg *ServerConfig
+	cfg          *ServerConfig
+	errorHandler func(err error)
+	exemplars    ExemplarExtractor
+	now          func() time.Time
+	series       atomic.Int64
+	reported     errorSet
+
+	collectorsMu sync.RWMutex
+	collectors   []namedCollector
 }
 
 func (s *Server) OptSortNames(sortNames bool) *Server {
@@ -30,44 +58,119 @@ func (s *Server) OptSortNames(sortNames bool) *Server {
 	return s
 }
 
+// OptErrorPolicy sets what happens to a sample whose call does not fit its family.
+func (s *Server) OptErrorPolicy(policy ErrorPolicy) *Server {
+	s.cfg.OnError = policy
+	return s
+}
+
+// OptNameValidation sets whether invalid metric and label names are errors or get sanitized.
+func (s *Server) OptNameValidation(validation NameValidation) *Server {
+	s.cfg.NameValidation = validation
+	return s
+}
+
+// OptErrorHandler sets the handler errors are reported to, instead of the standard logger.
+func (s *Server) OptErrorHandler(handler func(err error)) *Server {
+	s.errorHandler = handler
+	return s
+}
+
+// OptSeriesTTL sets how long a series may stay idle before it is evicted, zero disables expiry.
+func (s *Server) OptSeriesTTL(ttl time.Duration) *Server {
+	s.cfg.SeriesTTL = ttl
+	return s
+}
+
+// OptMaxSeries caps the series count of the whole server, zero means unlimited.
+func (s *Server) OptMaxSeries(maxSeries int) *Server {
+	s.cfg.MaxSeries = maxSeries
+	return s
+}
+
+// OptMaxFamilySeries caps the series count of families not setting their own cap.
+func (s *Server) OptMaxFamilySeries(maxSeries int) *Server {
+	s.cfg.MaxFamilySeries = maxSeries
+	return s
+}
+
+// OptCreatedLines sets whether OpenMetrics exports carry _created lines of counters, histograms and summaries.
+func (s *Server) OptCreatedLines(createdLines bool) *Server {
+	s.cfg.CreatedLines = createdLines
+	return s
+}
+
+// OptWithUnit sets whether OpenMetrics exports carry # UNIT lines, suffixing metric names with their unit.
+func (s *Server) OptWithUnit(withUnit bool) *Server {
+	s.cfg.WithUnit = withUnit
+	return s
+}
+
+// OptClock replaces time.Now, e.g. to test expiry.
+func (s *Server) OptClock(now func() time.Time) *Server {
+	s.now = now
+	return s
+}
+
 func (s *Server) Counter(name string) *counter {
 	return &counter{
-		name:    name,
+		desc:    desc{name: name},
 		storage: s.counters,
 	}
 }
 
 func (s *Server) Gauge(name string) *gauge {
 	return &gauge{
-		name:    name,
+		desc:    desc{name: name},
 		storage: s.gauges,
 	}
 }
 
 func (s *Server) Histogram(name string) *histogram {
 	return &histogram{
-		name:    name,
+		desc:    desc{name: name, defaultBuckets: DefaultBuckets},
 		storage: s.histograms,
 	}
 }
 
 func (s *Server) Summary(name string) *summary {
 	return &summary{
-		name:    name,
+		desc:    desc{name: name},
 		storage: s.summaries,
 	}
 }
 
+func (s *Server) CounterVec(name string, labelNames ...string) *counterVec {
+	return newCounterVec(name, s.counters, labelNames)
+}
+
+func (s *Server) GaugeVec(name string, labelNames ...string) *gaugeVec {
+	return newGaugeVec(name, s.gauges, labelNames)
+}
+
+func (s *Server) HistogramVec(name string, labelNames ...string) *histogramVec {
+	return newHistogramVec(name, s.histograms, labelNames)
+}
+
+func (s *Server) SummaryVec(name string, labelNames ...string) *summaryVec {
+	return newSummaryVec(name, s.summaries, labelNames)
+}
+
 func NewServer() *Server {
-	return &Server{
-		counters:   NewStorage(),
-		gauges:     NewStorage(),
-		histograms: NewStorage(),
-		summaries:  NewStorage(),
+	s := &Server{
 		cfg: &ServerConfig{
-			SortNames: false,
+			SortNames:       false,
+			OnError:         ErrorPolicyReport,
+			NameValidation:  NameValidationStrict,
+			CallbackTimeout: DefaultCallbackTimeout,
 		},
+		now: time.Now,
 	}
+	s.counters = NewStorage(s)
+	s.gauges = NewStorage(s)
+	s.histograms = NewStorage(s)
+	s.summaries = NewStorage(s)
+	return s
 }
 
 func (s *Server) SortNames(sortNames bool) *Server {
@@ -75,13 +178,121 @@ func (s *Server) SortNames(sortNames bool) *Server {
 	return s
 }
 
+// Delete removes the series of the named family with exactly the given labels,
+// an interleaved key-value-key-value... slice, telling whether it existed.
+func (s *Server) Delete(name string, labels ...string) bool {
+	key := makeKey(name, sortLabels(NewLabelPairs(labels...)))
+	removed := 0
+	for _, st := range s.storages() {
+		removed += st.deleteSeries(name, func(metricState *state) bool {
+			return metricState.key == key
+		})
+	}
+	return removed > 0
+}
+
+// DeleteMatching removes every series of the named family having all the given labels,
+// an interleaved key-value-key-value... slice, telling how many were removed.
+func (s *Server) DeleteMatching(name string, labels ...string) int {
+	match := NewLabelPairs(labels...)
+	removed := 0
+	for _, st := range s.storages() {
+		removed += st.deleteSeries(name, func(metricState *state) bool {
+			return hasLabels(metricState.Dto.Label, match)
+		})
+	}
+	return removed
+}
+
+// Reset removes all series of the named family, keeping the family schema registered.
+func (s *Server) Reset(name string) int {
+	removed := 0
+	for _, st := range s.storages() {
+		removed += st.deleteSeries(name, nil)
+	}
+	return removed
+}
+
+// Unregister removes the named family with all its series, telling whether it existed.
+func (s *Server) Unregister(name string) bool {
+	found := false
+	for _, st := range s.storages() {
+		if st.unregister(name) {
+			found = true
+		}
+	}
+	return found
+}
+
+// storages lists every built-in metric storage in export order.
+func (s *Server) storages() []*storage {
+	return []*storage{s.counters, s.gauges, s.histograms, s.summaries}
+}
+
+// gather evicts idle series, then merges the families of all storages and collectors into
+// one list, sorted by name when SortNames is set. A family name exported twice is
+// reported as ErrDuplicateFamily. Once ctx is done gather gives up with its error.
+func (s *Server) gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	s.Expire()
+	var families []*dto.MetricFamily
+	for _, st := range s.storages() {
+		families = st.collect(ctx, families)
+	}
+	families = s.collect(ctx, families)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if overflow := s.overflowFamily(); overflow != nil {
+		families = append(families, overflow)
+	}
+	if err := checkDuplicates(families); err != nil {
+		return nil, err
+	}
+	if s.cfg.SortNames {
+		sortFamilies(families)
+	}
+	return families, nil
+}
+
+func checkDuplicates(families []*dto.MetricFamily) error {
+	seen := make(map[string]dto.MetricType, len(families))
+	for _, fam := range families {
+		name := fam.GetName()
+		if prev, ok := seen[name]; ok {
+			return fmt.Errorf("%w: %q is exported as %s and %s", ErrDuplicateFamily, name, prev, fam.GetType())
+		}
+		seen[name] = fam.GetType()
+	}
+	return nil
+}
+
 func (s *Server) Export(w io.Writer, expFormat expfmt.Format, opts ...expfmt.EncoderOption) error {
+	return s.ExportContext(context.Background(), w, expFormat, opts...)
+}
+
+// ExportContext is Export giving up once ctx is done, CounterFunc and GaugeFunc
+// callbacks included, with the ctx error.
+func (s *Server) ExportContext(ctx context.Context, w io.Writer, expFormat expfmt.Format, opts ...expfmt.EncoderOption) error {
+	families, err := s.gather(ctx)
+	if err != nil {
+		return fmt.Errorf("gather(): %w", err)
+	}
+	if expFormat.FormatType() == expfmt.TypeOpenMetrics {
+		if families, err = s.openMetricsFamilies(families); err != nil {
+			return fmt.Errorf("openMetricsFamilies(): %w", err)
+		}
+		opts = append(s.openMetricsOptions(), opts...)
+	}
 	encoder := expfmt.NewEncoder(w, expFormat, opts...)
-	if err := s.counters.Encode(encoder, s.cfg.SortNames); err != nil {
-		return fmt.Errorf("counters.Encode(): %w", err)
+	for _, metricFamily := range families {
+		if err := encoder.Encode(metricFamily); err != nil {
+			return fmt.Errorf("expfmt.Encode(%s): %w", metricFamily.GetName(), err)
+		}
 	}
-	if err := s.histograms.Encode(encoder, s.cfg.SortNames); err != nil {
-		return fmt.Errorf("counters.Encode(): %w", err)
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("expfmt.Close(): %w", err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,267 @@
+package zpm_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestExportAllTypes(t *testing.T) {
+	srv := zpm.NewServer().SortNames(true)
+	srv.Summary("d_summary").Quantiles(0.5).Observe(1)
+	srv.Histogram("c_histogram").Buckets(1, 2).Observe(1)
+	srv.Gauge("b_gauge").Set(42)
+	srv.Counter("a_counter").Inc(1)
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	names := []string{"a_counter", "b_gauge", "c_histogram", "d_summary"}
+	last := -1
+	for _, name := range names {
+		idx := strings.Index(res, "# TYPE "+name)
+		assert.Greater(t, idx, last, "%s must be exported in sorted order", name)
+		last = idx
+	}
+}
+
+func TestExportDuplicateFamily(t *testing.T) {
+	srv := zpm.NewServer()
+	srv.Counter("dup").Inc(1)
+	srv.Gauge("dup").Set(1)
+	_, err := srv.String(zpm.FmtTextPlain)
+	assert.ErrorIs(t, err, zpm.ErrDuplicateFamily)
+}
+
+func TestSchemaMismatch(t *testing.T) {
+	var errs []error
+	srv := zpm.NewServer().
+		OptErrorPolicy(zpm.ErrorPolicyDrop).
+		OptErrorHandler(func(err error) { errs = append(errs, err) })
+	srv.Counter("ctr").Help("ctr help").Label("l1", "v1").Inc(1)
+	srv.Counter("ctr").Help("other help").Label("l1", "v1").Inc(1)
+	srv.Counter("ctr").Help("ctr help").Label("l2", "v1").Inc(1)
+	srv.Histogram("hist").Buckets(1, 2).Observe(1)
+	srv.Histogram("hist").Buckets(1, 2, 3).Observe(1)
+	srv.Counter("ctr").Help("other help").Label("l1", "v1").Inc(1)
+	srv.Histogram("default_hist").Observe(1)
+	srv.Histogram("default_hist").Buckets(zpm.DefaultBuckets...).Observe(1)
+	srv.Histogram("default_hist").Buckets(1, 2).Observe(1)
+	srv.Histogram("native_hist").NativeFactor(1.1).Observe(1)
+	srv.Histogram("native_hist").Buckets(1, 2).Observe(1)
+	require.Len(t, errs, 5, "a repeated mismatch is reported once, implicit buckets are registered")
+	for _, err := range errs {
+		assert.ErrorIs(t, err, zpm.ErrSchemaMismatch)
+	}
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `ctr{l1="v1"} 1`)
+	assert.NotContains(t, res, `l2="v1"`)
+	assert.Contains(t, res, "hist_count 1")
+
+	srv.OptErrorPolicy(zpm.ErrorPolicyPanic)
+	assert.Panics(t, func() { srv.Counter("ctr").Help("other help").Label("l1", "v1").Inc(1) })
+}
+
+func TestNameValidation(t *testing.T) {
+	var errs []error
+	srv := zpm.NewServer().
+		OptErrorPolicy(zpm.ErrorPolicyDrop).
+		OptErrorHandler(func(err error) { errs = append(errs, err) })
+	srv.Counter("my-metric.total").Inc(1)
+	srv.Counter("ctr").Label("__name__", "x").Inc(1)
+	srv.Histogram("hist").Label("le", "1").Observe(1)
+	srv.Summary("sum").Label("quantile", "1").Observe(1)
+	srv.Gauge("gauge").Label("le", "1").Set(1)
+	require.Len(t, errs, 4)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, zpm.ErrInvalidName)
+	}
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE gauge gauge\n", res[:strings.Index(res, "\n")+1])
+
+	srv = zpm.NewServer().OptNameValidation(zpm.NameValidationLenient)
+	srv.Counter("0my-metric.total").Label("path.name", "/").Label("__name__", "x").Inc(1)
+	srv.Histogram("hist").Label("le", "1").Observe(1)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `_0my_metric_total{exported___name__="x",path_name="/"} 1`)
+	assert.Contains(t, res, `hist_bucket{exported_le="1",le="+Inf"} 1`)
+
+	errs = nil
+	srv = zpm.NewServer().OptErrorHandler(func(err error) { errs = append(errs, err) })
+	for i := 0; i < 3; i++ {
+		srv.Counter("my-metric.total").Label("path", "/").Inc(1)
+	}
+	srv = srv.OptErrorPolicy(zpm.ErrorPolicyDrop)
+	for i := 0; i < 3; i++ {
+		srv.Counter("other-metric").Inc(1)
+	}
+	require.Len(t, errs, 2, "an invalid call site is reported once")
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `my_metric_total{path="/"} 3`, "sanitized on every sample")
+	assert.NotContains(t, res, "other")
+}
+
+func TestDelete(t *testing.T) {
+	srv := zpm.NewServer()
+	for _, worker := range []string{"w1", "w2", "w3"} {
+		srv.Gauge("jobs").Label("worker", worker).Label("pool", "p1").Set(1)
+	}
+	srv.Gauge("jobs").Label("worker", "w4").Label("pool", "p2").Set(1)
+	handle := srv.Gauge("jobs").Label("worker", "w1").Label("pool", "p1").Bind()
+
+	assert.True(t, srv.Delete("jobs", "pool", "p1", "worker", "w1"))
+	assert.False(t, srv.Delete("jobs", "worker", "w1"))
+	assert.Equal(t, 2, srv.DeleteMatching("jobs", "pool", "p1"))
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.NotContains(t, res, `pool="p1"`)
+	assert.Contains(t, res, `jobs{pool="p2",worker="w4"} 1`)
+
+	handle.Add(5)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `jobs{pool="p1",worker="w1"} 5`)
+
+	assert.Equal(t, 2, srv.Reset("jobs"))
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	srv.Gauge("jobs").Label("worker", "w1").Label("pool", "p1").Set(1)
+	assert.True(t, srv.Unregister("jobs"))
+	assert.False(t, srv.Unregister("jobs"))
+	srv.Counter("jobs").Inc(1)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "# TYPE jobs counter")
+}
+
+func TestSeriesTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	srv := zpm.NewServer().
+		OptClock(func() time.Time { return now }).
+		OptSeriesTTL(time.Minute)
+	srv.Gauge("tenant_jobs").Label("tenant", "t1").Set(1)
+	srv.Gauge("tenant_jobs").Label("tenant", "t2").Set(1)
+	srv.Counter("pinned").TTL(time.Hour).Inc(1)
+	handle := srv.Gauge("tenant_jobs").Label("tenant", "t3").Bind()
+
+	now = now.Add(50 * time.Second)
+	srv.Gauge("tenant_jobs").Label("tenant", "t1").Set(2)
+	handle.Set(3)
+	now = now.Add(20 * time.Second)
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `tenant_jobs{tenant="t1"} 2`)
+	assert.NotContains(t, res, `tenant="t2"`)
+	assert.Contains(t, res, `tenant_jobs{tenant="t3"} 3`)
+	assert.Contains(t, res, "pinned 1")
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 3, srv.Expire())
+	handle.Set(4)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE tenant_jobs gauge\n", res[:strings.Index(res, "\n")+1])
+	assert.Contains(t, res, `tenant_jobs{tenant="t3"} 4`)
+}
+
+func TestSeriesTTLNewSeries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	calls := 0
+	var srv *zpm.Server
+	srv = zpm.NewServer().OptSeriesTTL(time.Minute).OptClock(func() time.Time {
+		calls++
+		if calls == 2 {
+			// an eviction between the series creation and its first write
+			srv.Expire()
+		}
+		return now
+	})
+	srv.Counter("fresh").Inc(1)
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, "fresh 1\n", "a new series is not idle")
+}
+
+func TestSeriesTTLConcurrentEviction(t *testing.T) {
+	for name, write := range map[string]func(srv *zpm.Server) func(){
+		"looked_up": func(srv *zpm.Server) func() {
+			return func() { srv.Counter("looked_up").Inc(1) }
+		},
+		"bound": func(srv *zpm.Server) func() {
+			handle := srv.Counter("bound").Bind()
+			return func() { handle.Inc(1) }
+		},
+	} {
+		now := time.Unix(1700000000, 0)
+		evict := false
+		var srv *zpm.Server
+		srv = zpm.NewServer().OptSeriesTTL(time.Minute).OptClock(func() time.Time {
+			if evict {
+				// an eviction between the series lookup and its write
+				evict = false
+				srv.Expire()
+			}
+			return now
+		})
+		inc := write(srv)
+		inc()
+
+		now = now.Add(2 * time.Minute)
+		evict = true
+		inc()
+		res, err := srv.String(zpm.FmtTextPlain)
+		require.NoError(t, err)
+		assert.Contains(t, res, name+" 1\n", "the write goes to the series registered again")
+	}
+}
+
+func TestRunJanitor(t *testing.T) {
+	srv := zpm.NewServer().OptSeriesTTL(time.Millisecond)
+	srv.Gauge("short_lived").Set(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.RunJanitor(ctx, time.Millisecond)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	assert.Zero(t, srv.Reset("short_lived"), "janitor must evict the idle series")
+}
+
+func TestCardinalityLimits(t *testing.T) {
+	srv := zpm.NewServer().SortNames(true).OptMaxSeries(6)
+	for i := 0; i < 10; i++ {
+		srv.Counter("requests").MaxSeries(3).Label("user", strconv.Itoa(i)).Inc(1)
+	}
+	srv.Gauge("a_gauge").Label("pod", "p1").Set(1)
+	srv.Gauge("a_gauge").Label("pod", "p2").Set(1)
+	srv.Gauge("a_gauge").Label("pod", "p3").Set(1)
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `requests{user="2"} 1`)
+	assert.NotContains(t, res, `requests{user="3"}`)
+	assert.Contains(t, res, `requests{user="__overflow__"} 7`)
+	assert.Contains(t, res, `a_gauge{pod="p2"} 1`)
+	assert.Contains(t, res, `a_gauge{pod="__overflow__"} 1`)
+	assert.Contains(t, res, `zpm_series_overflow_total{family="requests"} 7`)
+	assert.Contains(t, res, `zpm_series_overflow_total{family="a_gauge"} 1`)
+
+	assert.Equal(t, 4, srv.DeleteMatching("requests"), "overflow series is removed too")
+	srv.Counter("requests").MaxSeries(3).Label("user", "42").Inc(1)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `requests{user="42"} 1`)
+}
@@ -1,6 +1,14 @@
 package zpm
 
-import dto "github.com/prometheus/client_model/go"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/xakepp35/zpm/algo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
 
 type (
 	Metric = dto.Metric
@@ -22,17 +30,122 @@ func NewLabelPairs(keyValues ...string) LabelPairs {
 }
 
 type state struct {
-	Dto  *Metric
-	Data any
+	Dto       *Metric
+	Data      any
+	key       string
+	family    *family
+	deleted   atomic.Bool
+	ttl       time.Duration
+	lastWrite atomic.Int64 // unix ms, tracked only when ttl is set
+	createdMs int64
+	callback  atomic.Pointer[callback] // set on CounterFunc and GaugeFunc series
+	overflow  bool                     // the family overflow series
+
+	hooksMu sync.Mutex
+	hooks   []func() // called once the series is deleted
 }
 
 type StateInitFunc = func(metricState *state)
 
+// snapshotter is series data read by a point-in-time snapshot
+type snapshotter interface {
+	snapshot(m *Metric)
+}
+
+// Snapshot returns a point-in-time copy of the series, safe to encode while writers run.
+// Counters and gauges keep their live value in Dto, other types in Data.
+// Samples carry no timestamp, the series creation time is exported
+// as the created timestamp of counters, histograms and summaries.
+func (s *state) Snapshot() *Metric {
+	res := &Metric{
+		Label: s.Dto.Label,
+	}
+	created := timestamppb.New(time.UnixMilli(s.createdMs))
+	if s.Dto.Counter != nil {
+		res.Counter = &dto.Counter{
+			Value:            ptr(algo.AtomicFloatLoad(s.Dto.Counter.Value)),
+			CreatedTimestamp: created,
+		}
+	}
+	if s.Dto.Gauge != nil {
+		res.Gauge = &dto.Gauge{
+			Value: ptr(algo.AtomicFloatLoad(s.Dto.Gauge.Value)),
+		}
+	}
+	if data, ok := s.Data.(snapshotter); ok {
+		data.snapshot(res)
+	}
+	if res.Histogram != nil {
+		res.Histogram.CreatedTimestamp = created
+	}
+	if res.Summary != nil {
+		res.Summary.CreatedTimestamp = created
+	}
+	return res
+}
+
+// newState makes a series created at timestampMs
 func newState(timestampMs int64, labels ...*LabelPair) *state {
 	return &state{
 		Dto: &Metric{
-			Label:       labels,
-			TimestampMs: &timestampMs,
+			Label: labels,
 		},
+		createdMs: timestampMs,
+	}
+}
+
+// onDelete registers fn to be called once the series is deleted,
+// right away when it already is
+func (s *state) onDelete(fn func()) {
+	s.hooksMu.Lock()
+	if !s.deleted.Load() {
+		s.hooks = append(s.hooks, fn)
+		s.hooksMu.Unlock()
+		return
+	}
+	s.hooksMu.Unlock()
+	fn()
+}
+
+// markDeleted marks the series deleted and calls its onDelete hooks
+func (s *state) markDeleted() {
+	s.deleted.Store(true)
+	s.hooksMu.Lock()
+	hooks := s.hooks
+	s.hooks = nil
+	s.hooksMu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// binding keeps the series of a pre-bound handle, resolving it again once deleted,
+// or once its family has room again when bound to the overflow series
+type binding[B interface{ State() *state }] struct {
+	builder B
+	current atomic.Pointer[state]
+}
+
+func (b *binding[B]) bind(builder B) {
+	b.builder = builder
+	b.current.Store(builder.State())
+}
+
+// bound returns the series the handle currently updates
+func (b *binding[B]) bound() *state {
+	return b.current.Load()
+}
+
+func (b *binding[B]) state() *state {
+	metricState := b.current.Load()
+	if metricState.ttl > 0 {
+		// touched before checking deleted, see state.claimIdle
+		metricState.touch()
+	}
+	if metricState.deleted.Load() ||
+		metricState.overflow && metricState.family.storage.hasRoom(metricState.family) {
+		metricState = b.builder.State()
+		b.current.Store(metricState)
 	}
+	return metricState
 }
@@ -1,90 +1,291 @@
 package zpm
 
 import (
-	"fmt"
+	"context"
+	"encoding/binary"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
 	"github.com/xakepp35/zpm/algo"
 )
 
 type storage struct {
 	mu       sync.RWMutex
+	srv      *Server
 	metrics  map[string]*state
-	families map[string]*dto.MetricFamily
+	families map[string]*family
 	names    []string
+
+	invalidNames sync.Map // series key → *checkedNames
+	invalidCount atomic.Int64
 }
 
-func NewStorage() *storage {
+func NewStorage(srv *Server) *storage {
 	return &storage{
+		srv:      srv,
 		metrics:  make(map[string]*state),
-		families: make(map[string]*dto.MetricFamily),
+		families: make(map[string]*family),
 	}
 }
 
-func (s *storage) Encode(encoder expfmt.Encoder, sortNames bool) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if sortNames {
-		sort.Strings(s.names)
+// collect appends the snapshots of registered families to dst in registration
+// order. Series are listed under the lock and snapshotted after it is released,
+// so writers are never blocked by an export.
+func (s *storage) collect(ctx context.Context, dst []*dto.MetricFamily) []*dto.MetricFamily {
+	type listed struct {
+		name   string
+		fam    *dto.MetricFamily
+		series []*state
 	}
+	var families []listed
+	s.mu.RLock()
 	for _, name := range s.names {
-		metricFamily := s.families[name]
-		if err := encoder.Encode(metricFamily); err != nil {
-			return fmt.Errorf("expfmt.Encode(%s): %v", *metricFamily.Name, err)
+		fam := s.families[name]
+		if len(fam.series) == 0 {
+			continue
 		}
+		families = append(families, listed{
+			name:   name,
+			fam:    fam.dto,
+			series: slices.Clone(fam.series),
+		})
 	}
-	return nil
+	s.mu.RUnlock()
+	for _, f := range families {
+		if ctx.Err() != nil {
+			return dst
+		}
+		metrics := make([]*dto.Metric, len(f.series))
+		for i, metricState := range f.series {
+			s.srv.evalCallback(ctx, f.name, metricState)
+			metrics[i] = metricState.Snapshot()
+		}
+		dst = append(dst, &dto.MetricFamily{
+			Name:   f.fam.Name,
+			Help:   f.fam.Help,
+			Type:   f.fam.Type,
+			Unit:   f.fam.Unit,
+			Metric: metrics,
+		})
+	}
+	return dst
+}
+
+func sortFamilies(families []*dto.MetricFamily) {
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
 }
 
-func (s *storage) demand(name string, help, unit *string, labels []*dto.LabelPair, metricType dto.MetricType, initMetric StateInitFunc) *state {
-	key := makeKey(name, labels)
+func (s *storage) demand(d *desc, labels []*dto.LabelPair, metricType dto.MetricType, initMetric StateInitFunc) *state {
+	labels = sortLabels(labels)
+	key := makeKey(d.name, labels)
 	metricState := s.get(key)
+	if metricState == nil {
+		// registered series have valid names, only new keys are checked
+		checked, checkedLabels, ok := s.checkNames(key, d, labels, metricType)
+		if !ok {
+			return newDetachedState(labels, initMetric)
+		}
+		if checked != d {
+			d, labels = checked, checkedLabels
+			key = makeKey(d.name, labels)
+			metricState = s.get(key)
+		}
+	}
+	var err error
 	if metricState != nil {
-		return metricState
+		err = metricState.family.schema.check(d, labels)
+	} else {
+		var overflowed bool
+		metricState, overflowed, err = s.create(key, d, labels, metricType, initMetric)
+		if overflowed {
+			metricState.family.reject(key)
+		}
+	}
+	if err != nil && s.srv.handleErrorOnce(err) {
+		return newDetachedState(labels, initMetric)
+	}
+	if metricState.ttl > 0 {
+		metricState.touch()
+		if metricState.deleted.Load() {
+			// evicted before the touch, the write goes to the series registered again
+			return s.demand(d, labels, metricType, initMetric)
+		}
+	}
+	return metricState
+}
+
+// newDetachedState makes a series that is never exported, for dropped samples to go to.
+func newDetachedState(labels []*dto.LabelPair, initMetric StateInitFunc) *state {
+	metricState := newState(algo.TimestampMs(), labels...)
+	initMetric(metricState)
+	return metricState
+}
+
+// create registers a new series, and its family on first use. A series that
+// does not fit the family schema is registered only under ErrorPolicyReport.
+// Past the cardinality limits the family overflow series is returned instead,
+// which is told by the returned true.
+func (s *storage) create(key string, d *desc, labels []*dto.LabelPair, metricType dto.MetricType, initMetric StateInitFunc) (*state, bool, error) {
+	if metricState := s.overflowed(d.name); metricState != nil {
+		return metricState, true, metricState.family.schema.check(d, labels)
 	}
-	// first time: init once
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	metricState = s.metrics[key]
+	metricState := s.metrics[key]
 	if metricState != nil {
-		return metricState
+		return metricState, false, metricState.family.schema.check(d, labels)
 	}
-	fam := s.families[name]
+	var err error
+	fam := s.families[d.name]
 	if fam == nil {
-		fam = s.registerName(name, help, unit, metricType)
+		fam = s.registerName(d, labels, metricType)
+	} else if err = fam.schema.check(d, labels); err != nil && s.srv.cfg.OnError != ErrorPolicyReport {
+		return nil, false, err
+	}
+	if !s.admit(fam) {
+		if fam.overflow == nil {
+			overflowLabels := makeOverflowLabels(labels)
+			fam.overflow = s.addSeries(makeKey(d.name, overflowLabels), fam, overflowLabels, initMetric)
+			fam.overflow.overflow = true
+			s.srv.series.Add(1)
+		}
+		return fam.overflow, true, err
 	}
-	timestampMs := algo.TimestampMs()
-	metricState = newState(timestampMs, labels...)
+	return s.addSeries(key, fam, labels, initMetric), false, err
+}
+
+// addSeries makes a new series of the family and registers it under key
+func (s *storage) addSeries(key string, fam *family, labels []*dto.LabelPair, initMetric StateInitFunc) *state {
+	timestampMs := s.srv.now().UnixMilli()
+	metricState := newState(timestampMs, labels...)
 	initMetric(metricState)
 	metricState.Dto.Label = labels
-	fam.Metric = append(fam.Metric, metricState.Dto)
+	metricState.key = key
+	metricState.family = fam
+	metricState.ttl = fam.ttl
+	if metricState.ttl == 0 {
+		metricState.ttl = s.srv.cfg.SeriesTTL
+	}
+	if metricState.ttl > 0 {
+		// written right away, a concurrent eviction must not see it idle
+		metricState.lastWrite.Store(timestampMs)
+	}
+	fam.series = append(fam.series, metricState)
 	s.metrics[key] = metricState
 	return metricState
 }
 
-func (s *storage) registerName(name string, help, unit *string, metricType dto.MetricType) *dto.MetricFamily {
-	res := &dto.MetricFamily{
-		Name: &name,
-		Help: help,
-		Type: metricType.Enum(),
-		Unit: unit,
+func (s *storage) registerName(d *desc, labels []*dto.LabelPair, metricType dto.MetricType) *family {
+	name := d.name
+	res := &family{
+		dto: &dto.MetricFamily{
+			Name: &name,
+			Help: d.help,
+			Type: metricType.Enum(),
+			Unit: d.unit,
+		},
+		schema:    newSchema(d, metricType, labels),
+		storage:   s,
+		ttl:       d.ttl,
+		maxSeries: d.maxSeries,
+	}
+	if res.maxSeries == 0 {
+		res.maxSeries = s.srv.cfg.MaxFamilySeries
 	}
 	s.families[name] = res
 	s.names = append(s.names, name)
 	return res
 }
 
-const labelsSeparator = "\u001d"
-
+// makeKey builds the series identity from the metric name and its labels,
+// which must be sorted by name. Every part is length-prefixed, so different
+// label sets never share a key, whatever bytes their names and values hold.
 func makeKey(name string, labels []*dto.LabelPair) string {
-	key := name
+	size := len(name) + 1
+	for _, lbl := range labels {
+		size += len(lbl.GetName()) + len(lbl.GetValue()) + 2
+	}
+	key := make([]byte, 0, size+8)
+	key = appendKeyPart(key, name)
 	for _, lbl := range labels {
-		key += labelsSeparator + *lbl.Value
+		key = appendKeyPart(key, lbl.GetName())
+		key = appendKeyPart(key, lbl.GetValue())
+	}
+	return string(key)
+}
+
+func appendKeyPart(key []byte, part string) []byte {
+	key = binary.AppendUvarint(key, uint64(len(part)))
+	return append(key, part...)
+}
+
+// sortLabels returns labels in canonical order, sorted by name.
+// Already sorted input is returned as is, otherwise a sorted copy is made.
+func sortLabels(labels []*dto.LabelPair) []*dto.LabelPair {
+	if sort.SliceIsSorted(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	}) {
+		return labels
+	}
+	res := append([]*dto.LabelPair(nil), labels...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+	return res
+}
+
+// deleteSeries removes the family series picked by match, telling how many were removed.
+// Removed states are marked deleted, so pre-bound handles resolve their series again.
+func (s *storage) deleteSeries(name string, match func(metricState *state) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fam := s.families[name]
+	if fam == nil {
+		return 0
 	}
-	return key
+	return s.deleteFamilySeries(fam, match)
+}
+
+func (s *storage) deleteFamilySeries(fam *family, match func(metricState *state) bool) int {
+	kept := fam.series[:0]
+	for _, metricState := range fam.series {
+		if match != nil && !match(metricState) {
+			kept = append(kept, metricState)
+			continue
+		}
+		metricState.markDeleted()
+		delete(s.metrics, metricState.key)
+		if metricState == fam.overflow {
+			fam.overflow = nil
+		}
+	}
+	removed := len(fam.series) - len(kept)
+	s.srv.series.Add(-int64(removed))
+	clear(fam.series[len(kept):])
+	fam.series = kept
+	return removed
+}
+
+// unregister removes the family along with all its series, so its name
+// may be registered again with another schema.
+func (s *storage) unregister(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fam := s.families[name]
+	if fam == nil {
+		return false
+	}
+	s.deleteFamilySeries(fam, nil)
+	delete(s.families, name)
+	s.names = slices.DeleteFunc(s.names, func(n string) bool {
+		return n == name
+	})
+	return true
 }
 
 func (s *storage) get(key string) *state {
@@ -92,3 +293,15 @@ func (s *storage) get(key string) *state {
 	defer s.mu.RUnlock()
 	return s.metrics[key]
 }
+
+// hasLabels tells whether labels contain every pair of match
+func hasLabels(labels, match []*dto.LabelPair) bool {
+	for _, m := range match {
+		if !slices.ContainsFunc(labels, func(lbl *dto.LabelPair) bool {
+			return lbl.GetName() == m.GetName() && lbl.GetValue() == m.GetValue()
+		}) {
+			return false
+		}
+	}
+	return true
+}
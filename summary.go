@@ -1,7 +1,11 @@
 package zpm
 
 import (
+	"fmt"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	dto "github.com/prometheus/client_model/go"
 	"github.com/xakepp35/zpm/algo"
@@ -9,12 +13,14 @@ import (
 
 // Summary client API
 type summary struct {
-	name      string
-	help      *string
-	unit      *string
-	labels    []*dto.LabelPair
-	quantiles []float64
-	storage   *storage
+	desc
+	labels     []*dto.LabelPair
+	storage    *storage
+	objectives []algo.Target // set by Objectives, nil for the default rank errors of quantiles
+	maxAge     time.Duration
+	ageBuckets int
+	sketch     func() algo.QuantileSketch
+	refresh    time.Duration
 }
 
 func (s *summary) Help(help string) *summary {
@@ -27,6 +33,18 @@ func (s *summary) Unit(unit string) *summary {
 	return s
 }
 
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (s *summary) TTL(ttl time.Duration) *summary {
+	s.ttl = ttl
+	return s
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (s *summary) MaxSeries(maxSeries int) *summary {
+	s.maxSeries = maxSeries
+	return s
+}
+
 func (c *summary) LabelPairs(labelPairs ...*LabelPair) *summary {
 	c.labels = append(c.labels, labelPairs...)
 	return c
@@ -39,53 +57,231 @@ func (c *summary) Label(key, value string) *summary {
 	})
 }
 
-// Quantiles - please provide quantile values in ascending order!
+// Quantiles tracks quantiles with default rank errors, see Objectives.
+// Quantiles outside [0, 1] are reported and dropped.
 func (s *summary) Quantiles(quantiles ...float64) *summary {
-	s.quantiles = quantiles
+	s.objectives = nil
+	if ascendingQuantiles(quantiles) {
+		// kept as is, builder chains on the hot path do not allocate
+		s.quantiles = quantiles
+		if s.quantiles == nil {
+			s.quantiles = []float64{}
+		}
+		return s
+	}
+	s.quantiles = make([]float64, 0, len(quantiles))
+	for _, q := range quantiles {
+		if q >= 0 && q <= 1 {
+			s.quantiles = append(s.quantiles, q)
+		} else {
+			s.storage.srv.handleErrorOnce(fmt.Errorf("%w: summary %q quantile %v", ErrInvalidQuantiles, s.name, q))
+		}
+	}
+	slices.Sort(s.quantiles)
+	s.quantiles = slices.Compact(s.quantiles)
 	return s
 }
 
-func (s *summary) State() *state {
-	return s.storage.demand(s.name, s.help, s.unit, s.labels, dto.MetricType_SUMMARY, s.initMetrics)
+// ascendingQuantiles tells whether quantiles are in [0, 1] and strictly ascending
+func ascendingQuantiles(quantiles []float64) bool {
+	prev := -1.0
+	for _, q := range quantiles {
+		if !(q > prev && q <= 1) {
+			return false
+		}
+		prev = q
+	}
+	return true
 }
 
-func (s *summary) Observe(value float64) *summary {
-	metricState := s.State()
-	atomic.AddUint64(metricState.Dto.Summary.SampleCount, 1)
-	algo.AtomicFloatAdd(metricState.Dto.Summary.SampleSum, value)
-	ckms := metricState.Data.(*algo.CKMSLockless)
-	if ckms == nil {
-		panic("CKMS is not initialized")
+// Objectives tracks quantiles, each with its allowed rank error,
+// e.g. {0.5: 0.05, 0.9: 0.01, 0.99: 0.001}. Quantiles outside [0, 1], and rank errors
+// not above zero, which would keep every observation, are reported and dropped.
+// Quantiles 0 and 1 are exact, their rank errors are not used.
+func (s *summary) Objectives(objectives map[float64]float64) *summary {
+	s.quantiles = make([]float64, 0, len(objectives))
+	for q, epsilon := range objectives {
+		switch {
+		case !(q >= 0 && q <= 1):
+			s.storage.srv.handleErrorOnce(fmt.Errorf("%w: summary %q quantile %v", ErrInvalidQuantiles, s.name, q))
+		case q > 0 && q < 1 && !(epsilon > 0):
+			s.storage.srv.handleErrorOnce(fmt.Errorf("%w: summary %q quantile %v rank error %v", ErrInvalidQuantiles, s.name, q, epsilon))
+		default:
+			s.quantiles = append(s.quantiles, q)
+		}
 	}
-	ckms.Insert(value)
-	for _, q := range metricState.Dto.Summary.Quantile {
-		*q.Value = ckms.Query(*q.Quantile)
+	slices.Sort(s.quantiles)
+	s.objectives = make([]algo.Target, len(s.quantiles))
+	for i, q := range s.quantiles {
+		s.objectives[i] = algo.Target{Quantile: q, Epsilon: objectives[q]}
 	}
 	return s
 }
 
+// defaultObjective is the rank error of a quantile set by Quantiles:
+// 0.05 for the median, 0.01 for the 90th percentile, 0.001 for the 99th.
+func defaultObjective(q float64) float64 {
+	return min(0.05, (1-q)/10)
+}
+
+// MaxAge makes quantiles reflect only the observations of the last maxAge,
+// count and sum still cover all of them. Zero keeps all observations.
+func (s *summary) MaxAge(maxAge time.Duration) *summary {
+	s.maxAge = maxAge
+	return s
+}
+
+// AgeBuckets sets how many estimators rotate through MaxAge, defaults to DefaultAgeBuckets.
+// More buckets let old observations decay out more smoothly, at the cost of slower observations.
+func (s *summary) AgeBuckets(ageBuckets int) *summary {
+	s.ageBuckets = ageBuckets
+	return s
+}
+
+// Sketch picks the quantile estimator backend, e.g. algo.NewDDSketch for relative
+// errors, algo.NewTDigest for accurate tails or algo.NewLogLinear for fixed memory.
+// It defaults to algo.CKMS, the only backend honoring the Objectives rank errors.
+func (s *summary) Sketch(newSketch func() algo.QuantileSketch) *summary {
+	s.sketch = newSketch
+	return s
+}
+
+// QuantileRefresh caches quantile values computed by an export for refresh,
+// sparing frequent scrapes of many series the estimator queries. Zero computes them on every export.
+func (s *summary) QuantileRefresh(refresh time.Duration) *summary {
+	s.refresh = refresh
+	return s
+}
+
+func (s *summary) State() *state {
+	return s.storage.demand(&s.desc, s.labels, dto.MetricType_SUMMARY, s.initMetrics)
+}
+
+// Bind resolves the series once, so the returned handle updates it without further lookups.
+func (s *summary) Bind() *SummaryHandle {
+	builder := *s
+	handle := &SummaryHandle{}
+	handle.bind(&builder)
+	return handle
+}
+
+func (s *summary) Observe(value float64) *summary {
+	updateSummary(s.State(), value)
+	return s
+}
+
 func (s *summary) initMetrics(metricState *state) {
-	metricState.Dto.Summary = &dto.Summary{
-		SampleCount: new(uint64),
-		SampleSum:   new(float64),
-		Quantile:    makeQuantiles(s.quantiles),
+	objectives := s.targets()
+	newSketch := s.sketch
+	if newSketch == nil {
+		newSketch = func() algo.QuantileSketch {
+			return algo.NewCKMS(objectives...)
+		}
+	}
+	metricState.Data = newSummaryData(newWindow(newSketch, s.maxAge, s.ageBuckets, s.storage.srv.now), objectives, s.refresh)
+}
+
+// targets are the objectives set by Objectives, or the quantiles with default rank errors
+func (s *summary) targets() []algo.Target {
+	if s.objectives != nil {
+		return s.objectives
+	}
+	res := make([]algo.Target, len(s.quantiles))
+	for i, q := range s.quantiles {
+		res[i] = algo.Target{Quantile: q, Epsilon: defaultObjective(q)}
+	}
+	return res
+}
+
+// summaryData is the live data of a summary series.
+// Observations only feed the estimator, quantiles are queried by exports.
+type summaryData struct {
+	hotCold[*summaryShard]
+	window     *window // targeted quantiles estimation
+	quantiles  []float64
+	refreshMs  int64
+	mu         sync.Mutex // guards the cached values
+	values     []float64  // replaced, never written in place
+	computedMs int64
+}
+
+type summaryShard struct {
+	count atomic.Uint64
+	sum   float64 // written atomically
+}
+
+func newSummaryData(window *window, objectives []algo.Target, refresh time.Duration) *summaryData {
+	res := &summaryData{
+		window:    window,
+		quantiles: make([]float64, len(objectives)),
+		refreshMs: refresh.Milliseconds(),
+	}
+	for i, objective := range objectives {
+		res.quantiles[i] = objective.Quantile
 	}
-	// CKMS для оценки квантилей
-	metricState.Data = algo.NewCKMSLockless(s.quantiles...)
+	for i := range res.shards {
+		res.shards[i] = &summaryShard{}
+	}
+	return res
 }
 
-func updateSummary(s *dto.Summary, value float64) {
+func updateSummary(metricState *state, value float64) {
+	d := metricState.Data.(*summaryData)
+	hot := d.hot()
+	algo.AtomicFloatAdd(&hot.sum, value)
+	hot.count.Add(1)
+	d.window.insert(value)
+}
 
+// quantileValues queries the estimator, unless the values cached by a previous call are fresh
+func (d *summaryData) quantileValues() []float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nowMs := d.window.now().UnixMilli()
+	if d.values != nil && nowMs-d.computedMs < d.refreshMs {
+		return d.values
+	}
+	values := make([]float64, len(d.quantiles))
+	for i, q := range d.quantiles {
+		values[i] = d.window.query(q)
+	}
+	d.values, d.computedMs = values, nowMs
+	return values
 }
 
-func makeQuantiles(quantiles []float64) []*dto.Quantile {
-	qvals := make([]float64, len(quantiles))
-	quantileList := make([]*dto.Quantile, len(quantiles))
-	for i, q := range quantiles {
-		quantileList[i] = &dto.Quantile{
-			Quantile: &q,
-			Value:    &qvals[i], // Изначально NaN
+func (d *summaryData) snapshot(m *dto.Metric) {
+	res := &dto.Summary{
+		Quantile: make([]*dto.Quantile, len(d.quantiles)),
+	}
+	d.read(func(cold *summaryShard) {
+		res.SampleCount = ptr(cold.count.Load())
+		res.SampleSum = ptr(algo.AtomicFloatLoad(&cold.sum))
+	})
+	values := d.quantileValues()
+	for i := range d.quantiles {
+		res.Quantile[i] = &dto.Quantile{
+			Quantile: &d.quantiles[i],
+			Value:    &values[i],
 		}
 	}
-	return quantileList
+	m.Summary = res
+}
+
+func (s *summaryShard) completed() uint64 {
+	return s.count.Load()
+}
+
+func (s *summaryShard) merge(cold *summaryShard) {
+	algo.AtomicFloatAdd(&s.sum, algo.AtomicFloatSwap(&cold.sum, 0))
+	s.count.Add(cold.count.Swap(0))
+}
+
+// SummaryHandle is a summary series pre-bound by summary.Bind
+type SummaryHandle struct {
+	binding[*summary]
+}
+
+func (h *SummaryHandle) Observe(value float64) *SummaryHandle {
+	updateSummary(h.state(), value)
+	return h
 }
@@ -1,12 +1,17 @@
 package zpm_test
 
 import (
+	"errors"
+	"math"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/xakepp35/zpm"
+	"github.com/xakepp35/zpm/algo"
 )
 
 func TestSummary(t *testing.T) {
@@ -27,7 +32,7 @@ func TestSummary(t *testing.T) {
 		}()
 	}
 	wg.Wait()
-	summary := zpm.Summary(name).State().Dto.Summary
+	summary := zpm.Summary(name).State().Snapshot().Summary
 	// Проверяем, что SampleCount и SampleSum обновились правильно
 	assert.Equal(t, uint64(len(values)), *summary.SampleCount)
 	assert.Equal(t, expectedSum, *summary.SampleSum)
@@ -43,3 +48,118 @@ func TestSummary(t *testing.T) {
 		assert.InDelta(t, expected, *q.Value, 5.0, "Квантиль %v не в допустимом диапазоне", *q.Quantile)
 	}
 }
+
+func TestSummaryObjectives(t *testing.T) {
+	srv := zpm.NewServer()
+	summary := srv.Summary("objectives").Objectives(map[float64]float64{0.99: 0.001, 0.5: 0.05})
+	const n = 10000
+	for i := 1; i <= n; i++ {
+		summary.Observe(float64(i))
+	}
+	snapshot := summary.State().Snapshot().Summary
+	require.Len(t, snapshot.Quantile, 2)
+	assert.Equal(t, 0.5, snapshot.Quantile[0].GetQuantile(), "quantiles are sorted")
+	assert.InDelta(t, 5000, snapshot.Quantile[0].GetValue(), 0.05*n)
+	assert.Equal(t, 0.99, snapshot.Quantile[1].GetQuantile())
+	assert.InDelta(t, 9900, snapshot.Quantile[1].GetValue(), 0.001*n)
+}
+
+func TestSummaryInvalidQuantile(t *testing.T) {
+	var reported []error
+	srv := zpm.NewServer().OptErrorHandler(func(err error) {
+		reported = append(reported, err)
+	})
+	for i := 0; i < 5; i++ {
+		srv.Summary("invalid_quantile").Quantiles(0.5, 1.5).Observe(1)
+	}
+	snapshot := srv.Summary("invalid_quantile").Quantiles(0.5, 1.5).State().Snapshot().Summary
+	require.Len(t, reported, 1, "an invalid call site is reported once")
+	assert.True(t, errors.Is(reported[0], zpm.ErrInvalidQuantiles))
+	assert.Len(t, snapshot.Quantile, 1)
+
+	reported = nil
+	snapshot = srv.Summary("invalid_epsilon").
+		Objectives(map[float64]float64{0.5: 0, 0.9: math.NaN(), 0.99: 0.001, 1: 0}).
+		Observe(1).State().Snapshot().Summary
+	require.Len(t, reported, 2, "rank errors not above zero disable compression")
+	for _, err := range reported {
+		assert.True(t, errors.Is(err, zpm.ErrInvalidQuantiles))
+	}
+	require.Len(t, snapshot.Quantile, 2)
+	assert.Equal(t, 0.99, snapshot.Quantile[0].GetQuantile())
+	assert.Equal(t, 1.0, snapshot.Quantile[1].GetQuantile())
+
+	srv.OptErrorPolicy(zpm.ErrorPolicyPanic)
+	assert.Panics(t, func() { srv.Summary("invalid_quantile").Quantiles(2).Observe(1) })
+}
+
+func TestSummaryQuantilesAllocs(t *testing.T) {
+	srv := zpm.NewServer()
+	plain := testing.AllocsPerRun(100, func() { srv.Summary("plain").Observe(1) })
+	quantiles := testing.AllocsPerRun(100, func() { srv.Summary("quantiles").Quantiles(0.5, 0.9).Observe(1) })
+	assert.LessOrEqual(t, quantiles, plain+1, "only the variadic slice is allocated")
+}
+
+func TestSummaryMaxAge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := zpm.NewServer().OptClock(func() time.Time { return now })
+	summary := srv.Summary("windowed").Quantiles(0.5).MaxAge(time.Minute).AgeBuckets(3).Bind()
+	for i := 0; i < 100; i++ {
+		summary.Observe(1000)
+	}
+	now = now.Add(30 * time.Second)
+	summary.Observe(1)
+	snapshot := srv.Summary("windowed").State().Snapshot().Summary
+	assert.Equal(t, 1000.0, snapshot.Quantile[0].GetValue(), "old observations are still in the window")
+
+	now = now.Add(45 * time.Second)
+	summary.Observe(1)
+	snapshot = srv.Summary("windowed").State().Snapshot().Summary
+	assert.Equal(t, 1.0, snapshot.Quantile[0].GetValue(), "observations older than MaxAge decayed out")
+	assert.Equal(t, uint64(102), snapshot.GetSampleCount(), "count covers all observations")
+}
+
+func TestSummarySketch(t *testing.T) {
+	srv := zpm.NewServer()
+	for name, newSketch := range map[string]func() algo.QuantileSketch{
+		"ddsketch":  func() algo.QuantileSketch { return algo.NewDDSketch(0.01, 1024) },
+		"tdigest":   func() algo.QuantileSketch { return algo.NewTDigest(100) },
+		"loglinear": func() algo.QuantileSketch { return algo.NewLogLinear(1, 1e6, 7) },
+	} {
+		summary := srv.Summary("sketch_"+name).Quantiles(0.5, 0.99).Sketch(newSketch)
+		for i := 1; i <= 10000; i++ {
+			summary.Observe(float64(i))
+		}
+		snapshot := summary.State().Snapshot().Summary
+		assert.InEpsilon(t, 5000, snapshot.Quantile[0].GetValue(), 0.02, name)
+		assert.InEpsilon(t, 9900, snapshot.Quantile[1].GetValue(), 0.02, name)
+	}
+}
+
+func TestSummaryQuantileRefresh(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := zpm.NewServer().OptClock(func() time.Time { return now })
+	summary := srv.Summary("refreshed").Quantiles(0.5).QuantileRefresh(10 * time.Second).Bind()
+	summary.Observe(1)
+	snapshot := srv.Summary("refreshed").State().Snapshot().Summary
+	assert.Equal(t, 1.0, snapshot.Quantile[0].GetValue())
+
+	summary.Observe(5).Observe(5)
+	snapshot = srv.Summary("refreshed").State().Snapshot().Summary
+	assert.Equal(t, 1.0, snapshot.Quantile[0].GetValue(), "cached quantiles")
+	assert.Equal(t, uint64(3), snapshot.GetSampleCount(), "count and sum are never cached")
+
+	now = now.Add(10 * time.Second)
+	snapshot = srv.Summary("refreshed").State().Snapshot().Summary
+	assert.Equal(t, 5.0, snapshot.Quantile[0].GetValue(), "refreshed quantiles")
+}
+
+func BenchmarkSummaryObserve(b *testing.B) {
+	srv := zpm.NewServer()
+	summary := srv.Summary("bench_observe").Quantiles(0.5, 0.9, 0.99).Bind()
+	b.RunParallel(func(pb *testing.PB) {
+		for v := 0.0; pb.Next(); v++ {
+			summary.Observe(v)
+		}
+	})
+}
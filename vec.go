@@ -0,0 +1,347 @@
+package zpm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xakepp35/zpm/algo"
+)
+
+// vec caches pre-bound handles of one family, keyed by positional label values.
+// Handles of overflowed or dropped combinations are not cached,
+// and cached ones are dropped once their series is deleted or expires.
+type vec[H interface{ bound() *state }] struct {
+	labelNames []string
+	handles    sync.Map
+	bind       func(labels LabelPairs) H
+}
+
+func (v *vec[H]) get(values []string) (H, error) {
+	if len(values) != len(v.labelNames) {
+		var zero H
+		return zero, fmt.Errorf("%w: got %d values for labels %q", ErrLabelCount, len(values), v.labelNames)
+	}
+	var buf []byte
+	for _, value := range values {
+		buf = appendKeyPart(buf, value)
+	}
+	key := string(buf)
+	if h, ok := v.handles.Load(key); ok {
+		return h.(H), nil
+	}
+	values = append([]string(nil), values...)
+	labels := make(LabelPairs, len(values))
+	for i := range values {
+		labels[i] = &LabelPair{
+			Name:  &v.labelNames[i],
+			Value: &values[i],
+		}
+	}
+	h := v.bind(labels)
+	metricState := h.bound()
+	if metricState.overflow || metricState.family == nil {
+		return h, nil
+	}
+	actual, loaded := v.handles.LoadOrStore(key, h)
+	if !loaded {
+		metricState.onDelete(func() {
+			v.handles.CompareAndDelete(key, h)
+		})
+	}
+	return actual.(H), nil
+}
+
+func (v *vec[H]) with(values []string) H {
+	h, err := v.get(values)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// CounterVec client API: counter family with declared label names
+type counterVec struct {
+	tpl counter
+	vec[*CounterHandle]
+}
+
+func newCounterVec(name string, storage *storage, labelNames []string) *counterVec {
+	v := &counterVec{
+		tpl: counter{
+			desc:    desc{name: name},
+			storage: storage,
+		},
+	}
+	v.labelNames = append([]string(nil), labelNames...)
+	v.bind = func(labels LabelPairs) *CounterHandle {
+		c := v.tpl
+		c.labels = labels
+		return c.Bind()
+	}
+	return v
+}
+
+func (v *counterVec) Help(help string) *counterVec {
+	v.tpl.Help(help)
+	return v
+}
+
+func (v *counterVec) Unit(unit string) *counterVec {
+	v.tpl.Unit(unit)
+	return v
+}
+
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (v *counterVec) TTL(ttl time.Duration) *counterVec {
+	v.tpl.TTL(ttl)
+	return v
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (v *counterVec) MaxSeries(maxSeries int) *counterVec {
+	v.tpl.MaxSeries(maxSeries)
+	return v
+}
+
+// With returns the cached handle for label values given in declaration order.
+// It panics when the values count does not match the label names.
+func (v *counterVec) With(values ...string) *CounterHandle {
+	return v.with(values)
+}
+
+// Get is With, reporting a label values count mismatch as an error.
+func (v *counterVec) Get(values ...string) (*CounterHandle, error) {
+	return v.get(values)
+}
+
+// GaugeVec client API: gauge family with declared label names
+type gaugeVec struct {
+	tpl gauge
+	vec[*GaugeHandle]
+}
+
+func newGaugeVec(name string, storage *storage, labelNames []string) *gaugeVec {
+	v := &gaugeVec{
+		tpl: gauge{
+			desc:    desc{name: name},
+			storage: storage,
+		},
+	}
+	v.labelNames = append([]string(nil), labelNames...)
+	v.bind = func(labels LabelPairs) *GaugeHandle {
+		g := v.tpl
+		g.labels = labels
+		return g.Bind()
+	}
+	return v
+}
+
+func (v *gaugeVec) Help(help string) *gaugeVec {
+	v.tpl.Help(help)
+	return v
+}
+
+func (v *gaugeVec) Unit(unit string) *gaugeVec {
+	v.tpl.Unit(unit)
+	return v
+}
+
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (v *gaugeVec) TTL(ttl time.Duration) *gaugeVec {
+	v.tpl.TTL(ttl)
+	return v
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (v *gaugeVec) MaxSeries(maxSeries int) *gaugeVec {
+	v.tpl.MaxSeries(maxSeries)
+	return v
+}
+
+// With returns the cached handle for label values given in declaration order.
+// It panics when the values count does not match the label names.
+func (v *gaugeVec) With(values ...string) *GaugeHandle {
+	return v.with(values)
+}
+
+// Get is With, reporting a label values count mismatch as an error.
+func (v *gaugeVec) Get(values ...string) (*GaugeHandle, error) {
+	return v.get(values)
+}
+
+// HistogramVec client API: histogram family with declared label names
+type histogramVec struct {
+	tpl histogram
+	vec[*HistogramHandle]
+}
+
+func newHistogramVec(name string, storage *storage, labelNames []string) *histogramVec {
+	v := &histogramVec{
+		tpl: histogram{
+			desc:    desc{name: name, defaultBuckets: DefaultBuckets},
+			storage: storage,
+		},
+	}
+	v.labelNames = append([]string(nil), labelNames...)
+	v.bind = func(labels LabelPairs) *HistogramHandle {
+		h := v.tpl
+		h.labels = labels
+		return h.Bind()
+	}
+	return v
+}
+
+func (v *histogramVec) Help(help string) *histogramVec {
+	v.tpl.Help(help)
+	return v
+}
+
+func (v *histogramVec) Unit(unit string) *histogramVec {
+	v.tpl.Unit(unit)
+	return v
+}
+
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (v *histogramVec) TTL(ttl time.Duration) *histogramVec {
+	v.tpl.TTL(ttl)
+	return v
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (v *histogramVec) MaxSeries(maxSeries int) *histogramVec {
+	v.tpl.MaxSeries(maxSeries)
+	return v
+}
+
+// Buckets sets the classic bucket bounds, see histogram.Buckets
+func (v *histogramVec) Buckets(buckets ...float64) *histogramVec {
+	v.tpl.Buckets(buckets...)
+	return v
+}
+
+// Native enables sparse exponential buckets, see histogram.Native
+func (v *histogramVec) Native(schema int32) *histogramVec {
+	v.tpl.Native(schema)
+	return v
+}
+
+// NativeFactor enables native buckets growing by at most factor, see histogram.NativeFactor
+func (v *histogramVec) NativeFactor(factor float64) *histogramVec {
+	v.tpl.NativeFactor(factor)
+	return v
+}
+
+// NativeMaxBuckets caps the native bucket count, see histogram.NativeMaxBuckets
+func (v *histogramVec) NativeMaxBuckets(maxBuckets uint32) *histogramVec {
+	v.tpl.NativeMaxBuckets(maxBuckets)
+	return v
+}
+
+// NativeZeroThreshold sets the width of the native zero bucket, see histogram.NativeZeroThreshold
+func (v *histogramVec) NativeZeroThreshold(threshold float64) *histogramVec {
+	v.tpl.NativeZeroThreshold(threshold)
+	return v
+}
+
+// With returns the cached handle for label values given in declaration order.
+// It panics when the values count does not match the label names.
+func (v *histogramVec) With(values ...string) *HistogramHandle {
+	return v.with(values)
+}
+
+// Get is With, reporting a label values count mismatch as an error.
+func (v *histogramVec) Get(values ...string) (*HistogramHandle, error) {
+	return v.get(values)
+}
+
+// SummaryVec client API: summary family with declared label names
+type summaryVec struct {
+	tpl summary
+	vec[*SummaryHandle]
+}
+
+func newSummaryVec(name string, storage *storage, labelNames []string) *summaryVec {
+	v := &summaryVec{
+		tpl: summary{
+			desc:    desc{name: name},
+			storage: storage,
+		},
+	}
+	v.labelNames = append([]string(nil), labelNames...)
+	v.bind = func(labels LabelPairs) *SummaryHandle {
+		s := v.tpl
+		s.labels = labels
+		return s.Bind()
+	}
+	return v
+}
+
+func (v *summaryVec) Help(help string) *summaryVec {
+	v.tpl.Help(help)
+	return v
+}
+
+func (v *summaryVec) Unit(unit string) *summaryVec {
+	v.tpl.Unit(unit)
+	return v
+}
+
+// TTL evicts series idle for longer than ttl, overriding the server SeriesTTL
+func (v *summaryVec) TTL(ttl time.Duration) *summaryVec {
+	v.tpl.TTL(ttl)
+	return v
+}
+
+// MaxSeries caps the family series count, overriding the server MaxFamilySeries
+func (v *summaryVec) MaxSeries(maxSeries int) *summaryVec {
+	v.tpl.MaxSeries(maxSeries)
+	return v
+}
+
+// Quantiles tracks quantiles with default rank errors, see summary.Quantiles
+func (v *summaryVec) Quantiles(quantiles ...float64) *summaryVec {
+	v.tpl.Quantiles(quantiles...)
+	return v
+}
+
+// Objectives tracks quantiles with their allowed rank errors, see summary.Objectives
+func (v *summaryVec) Objectives(objectives map[float64]float64) *summaryVec {
+	v.tpl.Objectives(objectives)
+	return v
+}
+
+// MaxAge makes quantiles reflect only the last maxAge, see summary.MaxAge
+func (v *summaryVec) MaxAge(maxAge time.Duration) *summaryVec {
+	v.tpl.MaxAge(maxAge)
+	return v
+}
+
+// AgeBuckets sets how many buckets the MaxAge window rotates through, see summary.AgeBuckets
+func (v *summaryVec) AgeBuckets(ageBuckets int) *summaryVec {
+	v.tpl.AgeBuckets(ageBuckets)
+	return v
+}
+
+// QuantileRefresh caches exported quantile values, see summary.QuantileRefresh
+func (v *summaryVec) QuantileRefresh(refresh time.Duration) *summaryVec {
+	v.tpl.QuantileRefresh(refresh)
+	return v
+}
+
+// Sketch picks the quantile estimator backend, see summary.Sketch
+func (v *summaryVec) Sketch(newSketch func() algo.QuantileSketch) *summaryVec {
+	v.tpl.Sketch(newSketch)
+	return v
+}
+
+// With returns the cached handle for label values given in declaration order.
+// It panics when the values count does not match the label names.
+func (v *summaryVec) With(values ...string) *SummaryHandle {
+	return v.with(values)
+}
+
+// Get is With, reporting a label values count mismatch as an error.
+func (v *summaryVec) Get(values ...string) (*SummaryHandle, error) {
+	return v.get(values)
+}
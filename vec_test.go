@@ -0,0 +1,86 @@
+package zpm_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xakepp35/zpm"
+)
+
+func TestCounterVec(t *testing.T) {
+	srv := zpm.NewServer()
+	requests := srv.CounterVec("http_requests_total", "method", "path").
+		Help("http requests counter")
+	const numIter = 100
+	var wg sync.WaitGroup
+	wg.Add(numIter)
+	for i := 0; i < numIter; i++ {
+		go func() {
+			defer wg.Done()
+			requests.With("GET", "/api").Inc(1)
+		}()
+	}
+	wg.Wait()
+	assert.Same(t, requests.With("GET", "/api"), requests.With("GET", "/api"))
+	value := srv.Counter("http_requests_total").
+		Label("method", "GET").
+		Label("path", "/api").
+		State().Dto.Counter.GetValue()
+	assert.Equal(t, float64(numIter), value)
+}
+
+func TestVecLabelCount(t *testing.T) {
+	srv := zpm.NewServer()
+	hist := srv.HistogramVec("latency", "method").Buckets(1, 10)
+	_, err := hist.Get("GET", "/api")
+	assert.ErrorIs(t, err, zpm.ErrLabelCount)
+	assert.Panics(t, func() { hist.With() })
+	h, err := hist.Get("GET")
+	require.NoError(t, err)
+	h.Observe(5)
+}
+
+func TestVecOverflow(t *testing.T) {
+	srv := zpm.NewServer()
+	users := srv.CounterVec("users", "user").MaxSeries(2)
+	users.With("a").Inc(1)
+	users.With("b").Inc(1)
+	c := users.With("c")
+	c.Inc(1)
+	c.Inc(1)
+	users.With("d").Inc(1)
+	users.With("c").Inc(1)
+	assert.NotSame(t, users.With("c"), users.With("c"), "overflowed combinations are not cached")
+
+	res, err := srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `users{user="__overflow__"} 4`)
+	assert.Contains(t, res, `zpm_series_overflow_total{family="users"} 2`, "counted once per combination")
+
+	require.True(t, srv.Delete("users", "user", "a"))
+	c.Inc(1)
+	res, err = srv.String(zpm.FmtTextPlain)
+	require.NoError(t, err)
+	assert.Contains(t, res, `users{user="c"} 1`, "the handle leaves the overflow series once there is room")
+	assert.Contains(t, res, `users{user="__overflow__"} 4`)
+}
+
+func TestVecDropsDeletedHandles(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := zpm.NewServer().OptClock(func() time.Time { return now })
+	sessions := srv.GaugeVec("sessions", "id").TTL(time.Minute)
+	first := sessions.With("x")
+	assert.Same(t, first, sessions.With("x"))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 1, srv.Expire())
+	assert.NotSame(t, first, sessions.With("x"), "expired series handles are dropped from the cache")
+
+	second := sessions.With("x")
+	require.True(t, srv.Delete("sessions", "id", "x"))
+	assert.NotSame(t, second, sessions.With("x"), "deleted series handles are dropped from the cache")
+}
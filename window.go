@@ -0,0 +1,82 @@
+package zpm
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xakepp35/zpm/algo"
+)
+
+// DefaultAgeBuckets is the age buckets count of summaries setting MaxAge without AgeBuckets
+const DefaultAgeBuckets = 5
+
+// window is a quantile estimator over the observations of the last maxAge.
+// It keeps ageBuckets estimators fed with every observation, each reset in turn
+// every maxAge/ageBuckets, and serves queries from the oldest one, which holds
+// between maxAge-maxAge/ageBuckets and maxAge of observations.
+// Without maxAge a single estimator holds all observations.
+type window struct {
+	streams   []algo.QuantileSketch
+	head      atomic.Int32 // oldest stream, serving queries
+	expiresMs atomic.Int64 // when the head stream is reset
+	bucketMs  int64
+	now       func() time.Time
+	mu        sync.Mutex // serializes rotations
+}
+
+func newWindow(newSketch func() algo.QuantileSketch, maxAge time.Duration, ageBuckets int, now func() time.Time) *window {
+	if maxAge <= 0 {
+		ageBuckets = 1
+	} else if ageBuckets <= 0 {
+		ageBuckets = DefaultAgeBuckets
+	}
+	res := &window{
+		streams: make([]algo.QuantileSketch, ageBuckets),
+		now:     now,
+	}
+	for i := range res.streams {
+		res.streams[i] = newSketch()
+	}
+	if maxAge > 0 {
+		res.bucketMs = max(1, maxAge.Milliseconds()/int64(ageBuckets))
+		res.expiresMs.Store(now().UnixMilli() + res.bucketMs)
+	}
+	return res
+}
+
+func (w *window) insert(value float64) {
+	w.rotate()
+	for _, stream := range w.streams {
+		stream.Insert(value)
+	}
+}
+
+func (w *window) query(q float64) float64 {
+	w.rotate()
+	return w.streams[w.head.Load()].Query(q)
+}
+
+// rotate resets the streams whose age bucket has passed, oldest first
+func (w *window) rotate() {
+	if w.bucketMs == 0 {
+		return
+	}
+	nowMs := w.now().UnixMilli()
+	if nowMs < w.expiresMs.Load() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	expiresMs := w.expiresMs.Load()
+	if nowMs < expiresMs {
+		return
+	}
+	passed := (nowMs-expiresMs)/w.bucketMs + 1
+	for range min(passed, int64(len(w.streams))) {
+		head := w.head.Load()
+		w.streams[head].Reset()
+		w.head.Store((head + 1) % int32(len(w.streams)))
+	}
+	w.expiresMs.Store(expiresMs + passed*w.bucketMs)
+}